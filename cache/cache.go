@@ -21,6 +21,14 @@ type Cacher interface {
 	Delete(ctx context.Context, key ...string) error
 }
 
+// AtomicCacher Cacher able to set a key only when it does not exist yet,
+// the Cacher of NewCache implement it.
+type AtomicCacher interface {
+	Cacher
+	// SetNX set key when it does not exist, false when it already exists
+	SetNX(ctx context.Context, key string, val interface{}, exp time.Duration) (bool, error)
+}
+
 type cache struct {
 	rds             redis.Cmdable
 	retentionSecond time.Duration
@@ -38,6 +46,10 @@ func (c *cache) Set(ctx context.Context, key string, val interface{}, exp time.D
 	return cmd.Err()
 }
 
+func (c *cache) SetNX(ctx context.Context, key string, val interface{}, exp time.Duration) (bool, error) {
+	return c.rds.SetNX(ctx, key, val, exp).Result()
+}
+
 func (c *cache) Get(ctx context.Context, key string) ([]byte, error) {
 	cmd := c.rds.Get(ctx, key)
 	b, e := cmd.Bytes()
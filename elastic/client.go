@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/elastic/go-elasticsearch/v7"
@@ -58,6 +59,25 @@ func (e *clientElastic) Version() (map[string]interface{}, error) {
 	return result, nil
 }
 
+func (e *clientElastic) ClusterHealth(ctx context.Context) (string, error) {
+	res, err := e.client.Cluster.Health(e.client.Cluster.Health.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("cluster health got: %s", res.String())
+	}
+
+	var result struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.Status, nil
+}
+
 func (e *clientElastic) Get(ctx context.Context, index string, id string) (map[string]interface{}, error) {
 	res, err := e.client.Get(index, id)
 	if err != nil {
@@ -0,0 +1,44 @@
+package elastic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterHealth(t *testing.T) {
+	code := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		// the client checks the product on its first request
+		if r.URL.Path == "/" {
+			_, _ = w.Write([]byte(`{"version":{"number":"7.17.7","build_flavor":"default"},"tagline":"You Know, for Search"}`))
+			return
+		}
+		assert.Equal(t, "/_cluster/health", r.URL.Path)
+		w.WriteHeader(code)
+		if code == http.StatusOK {
+			_, _ = w.Write([]byte(`{"cluster_name":"search","status":"yellow"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"error":"unavailable"}`))
+	}))
+	defer server.Close()
+
+	es, err := elasticsearch.NewClient(Config(&Configuration{Address: []string{server.URL}}))
+	assert.NoError(t, err)
+	client := &clientElastic{client: es}
+
+	status, err := client.ClusterHealth(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "yellow", status)
+
+	code = http.StatusServiceUnavailable
+	_, err = client.ClusterHealth(context.Background())
+	assert.Error(t, err)
+}
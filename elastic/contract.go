@@ -26,3 +26,11 @@ type Client interface {
 	Get(ctx context.Context, index string, id string) (map[string]interface{}, error)
 	Search(ctx context.Context, index string, query map[string]interface{}) (*SearchResult, error)
 }
+
+// HealthChecker report the health of the cluster, the client of NewClient
+// implements it. It is kept out of Client so existing implementations of
+// Client do not break.
+type HealthChecker interface {
+	// ClusterHealth return the cluster status, green, yellow or red
+	ClusterHealth(ctx context.Context) (string, error)
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kiriminaja/kaj-golang-pkg/elastic"
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+	"github.com/kiriminaja/kaj-golang-pkg/mongodb"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	errNoPinger        = errors.New("mongodb health check requires an adapter implementing mongodb.Pinger")
+	errNoHealthChecker = errors.New("elastic health check requires a client implementing elastic.HealthChecker")
+)
+
+type checkerFunc struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// CheckerFunc return a Checker named name running check
+func CheckerFunc(name string, check func(ctx context.Context) error) Checker {
+	return &checkerFunc{name: name, check: check}
+}
+
+func (c *checkerFunc) Name() string {
+	return c.name
+}
+
+func (c *checkerFunc) Check(ctx context.Context) error {
+	return c.check(ctx)
+}
+
+// Named return checker reported as name, to tell apart the checkers of two
+// clients of the same kind.
+//
+//	health.NewHealth(cfg, health.Named("mongodb-orders", health.Mongo(orders)), health.Named("mongodb-billing", health.Mongo(billing)))
+func Named(name string, checker Checker) Checker {
+	return &checkerFunc{name: name, check: checker.Check}
+}
+
+// Kafka check the brokers answer the metadata requests of admin
+func Kafka(admin kafka.Admin) Checker {
+	return CheckerFunc("kafka", func(context.Context) error {
+		return admin.Ping()
+	})
+}
+
+// Mongo check the server of adapter answer a ping, adapter must implement
+// mongodb.Pinger like the client of mongodb.NewMongoClient.
+func Mongo(adapter mongodb.Adapter) Checker {
+	return CheckerFunc("mongodb", func(ctx context.Context) error {
+		pinger, ok := adapter.(mongodb.Pinger)
+		if !ok {
+			return errNoPinger
+		}
+		return pinger.PingContext(ctx)
+	})
+}
+
+// Elastic check the cluster of client is not red, a yellow cluster with
+// unassigned replicas still serves requests. client must implement
+// elastic.HealthChecker like the client of elastic.NewClient.
+func Elastic(client elastic.Client) Checker {
+	return CheckerFunc("elastic", func(ctx context.Context) error {
+		checker, ok := client.(elastic.HealthChecker)
+		if !ok {
+			return errNoHealthChecker
+		}
+		status, err := checker.ClusterHealth(ctx)
+		if err != nil {
+			return err
+		}
+		if status == "red" {
+			return fmt.Errorf("cluster status is %s", status)
+		}
+		return nil
+	})
+}
+
+// Redis check the server of client answer a PING, client is the redis.Cmdable
+// given to cache.NewCache.
+func Redis(client redis.Cmdable) Checker {
+	return CheckerFunc("redis", func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+}
@@ -0,0 +1,57 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Checker check a dependency of the service, see CheckerFunc, Kafka, Mongo,
+// Elastic and Redis
+type Checker interface {
+	Name() string
+	// Check return an error when the dependency is not usable, it must
+	// return once ctx is done.
+	Check(ctx context.Context) error
+}
+
+// Health aggregate the checkers of the service
+type Health interface {
+	// Check run every checker, the results younger than Config.CacheSecond
+	// are reused.
+	Check(ctx context.Context) *Report
+	// Liveness answer 200 while the process serves requests, it does not
+	// run the checkers so a dependency outage does not restart the service.
+	Liveness() http.Handler
+	// Readiness answer the Report of Check, 200 when every checker is up
+	// and 503 otherwise.
+	Readiness() http.Handler
+}
+
+type Config struct {
+	// TimeoutSecond maximum duration of a check, defaults to 3.
+	TimeoutSecond int `json:"timeout_second" yaml:"timeout_second"`
+	// CacheSecond how long a result is reused, so frequent probes do not
+	// load the dependencies. Defaults to 5, negative disables the cache.
+	CacheSecond int `json:"cache_second" yaml:"cache_second"`
+}
+
+// Result outcome of a checker
+type Result struct {
+	Name      string        `json:"name"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration_ns"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Report results of every checker, Status is down when one of them is
+type Report struct {
+	Status string    `json:"status"`
+	Checks []*Result `json:"checks"`
+}
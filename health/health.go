@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+)
+
+const (
+	defaultTimeoutSecond = 3
+	defaultCacheSecond   = 5
+)
+
+type health struct {
+	checkers []Checker
+	timeout  time.Duration
+	cacheTTL time.Duration
+	mu       sync.Mutex
+	// cache results per checker index, two checkers may share a name
+	cache map[int]*Result
+	now   func() time.Time
+}
+
+// NewHealth return the Health of checkers
+func NewHealth(cfg *Config, checkers ...Checker) Health {
+	if cfg.TimeoutSecond < 1 {
+		cfg.TimeoutSecond = defaultTimeoutSecond
+	}
+	if cfg.CacheSecond == 0 {
+		cfg.CacheSecond = defaultCacheSecond
+	}
+	return &health{
+		checkers: checkers,
+		timeout:  time.Duration(cfg.TimeoutSecond) * time.Second,
+		cacheTTL: time.Duration(cfg.CacheSecond) * time.Second,
+		cache:    map[int]*Result{},
+		now:      time.Now,
+	}
+}
+
+func (h *health) Check(ctx context.Context) *Report {
+	report := &Report{Status: StatusUp, Checks: make([]*Result, len(h.checkers))}
+
+	var wg sync.WaitGroup
+	for i, checker := range h.checkers {
+		wg.Add(1)
+		go func(i int, checker Checker) {
+			defer wg.Done()
+			report.Checks[i] = h.result(ctx, i, checker)
+		}(i, checker)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// result return the cached result of the checker at index i or run it
+func (h *health) result(ctx context.Context, i int, checker Checker) *Result {
+	h.mu.Lock()
+	cached, ok := h.cache[i]
+	h.mu.Unlock()
+	if ok && h.now().Sub(cached.CheckedAt) < h.cacheTTL {
+		return cached
+	}
+
+	result := h.run(ctx, checker)
+	if result.Status != StatusUp {
+		logger.Warn(logger.SetMessageFormat("[health] %s is down: %s", result.Name, result.Error))
+	}
+	h.mu.Lock()
+	h.cache[i] = result
+	h.mu.Unlock()
+	return result
+}
+
+// run check checker within the timeout, a checker ignoring its context is
+// reported down once the timeout is over.
+func (h *health) run(ctx context.Context, checker Checker) *Result {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	started := h.now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("check panicked: %v", r)
+			}
+		}()
+		done <- checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out after %s", h.timeout)
+	}
+
+	result := &Result{
+		Name:      checker.Name(),
+		Status:    StatusUp,
+		Duration:  h.now().Sub(started),
+		CheckedAt: h.now(),
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (h *health) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &Report{Status: StatusUp, Checks: []*Result{}})
+	})
+}
+
+func (h *health) Readiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Check(r.Context())
+		code := http.StatusOK
+		if report.Status != StatusUp {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/elastic"
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+	"github.com/kiriminaja/kaj-golang-pkg/mongodb"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthReadiness(t *testing.T) {
+	var calls int32
+	up := CheckerFunc("mongodb", func(context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	down := CheckerFunc("redis", func(context.Context) error {
+		return errors.New("connection refused")
+	})
+	h := NewHealth(&Config{}, up, down)
+
+	rec := httptest.NewRecorder()
+	h.Readiness().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "connection refused")
+
+	// the second probe reuse the cached results
+	report := h.Check(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Equal(t, StatusUp, report.Checks[0].Status)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	rec = httptest.NewRecorder()
+	h.Liveness().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/live", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestHealthTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	stuck := CheckerFunc("kafka", func(context.Context) error {
+		// ignore the context like a client without deadline support
+		<-block
+		return nil
+	})
+	h := NewHealth(&Config{TimeoutSecond: 1, CacheSecond: -1}, stuck)
+
+	started := time.Now()
+	report := h.Check(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Contains(t, report.Checks[0].Error, "timed out")
+	assert.Less(t, time.Since(started), 2*time.Second)
+}
+
+type fakeAdmin struct {
+	kafka.Admin
+	err error
+}
+
+func (a *fakeAdmin) Ping() error {
+	return a.err
+}
+
+type fakeAdapter struct {
+	mongodb.Adapter
+}
+
+// fakePinger adapter implementing mongodb.Pinger
+type fakePinger struct {
+	fakeAdapter
+	err error
+}
+
+func (a *fakePinger) PingContext(context.Context) error {
+	return a.err
+}
+
+type fakeClient struct {
+	elastic.Client
+}
+
+// fakeElastic client implementing elastic.HealthChecker
+type fakeElastic struct {
+	fakeClient
+	status string
+	err    error
+}
+
+func (c *fakeElastic) ClusterHealth(context.Context) (string, error) {
+	return c.status, c.err
+}
+
+type fakeRedis struct {
+	redis.Cmdable
+	err error
+}
+
+func (c *fakeRedis) Ping(ctx context.Context) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx, "ping")
+	if c.err != nil {
+		cmd.SetErr(c.err)
+		return cmd
+	}
+	cmd.SetVal("PONG")
+	return cmd
+}
+
+func TestCheckers(t *testing.T) {
+	refused := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		checker Checker
+		err     string
+	}{
+		{"kafka up", Kafka(&fakeAdmin{}), ""},
+		{"kafka down", Kafka(&fakeAdmin{err: refused}), "connection refused"},
+		{"mongodb up", Mongo(&fakePinger{}), ""},
+		{"mongodb down", Mongo(&fakePinger{err: refused}), "connection refused"},
+		{"mongodb without pinger", Mongo(&fakeAdapter{}), errNoPinger.Error()},
+		{"elastic green", Elastic(&fakeElastic{status: "green"}), ""},
+		// unassigned replicas still serve requests
+		{"elastic yellow", Elastic(&fakeElastic{status: "yellow"}), ""},
+		{"elastic red", Elastic(&fakeElastic{status: "red"}), "cluster status is red"},
+		{"elastic down", Elastic(&fakeElastic{err: refused}), "connection refused"},
+		{"elastic without health checker", Elastic(&fakeClient{}), errNoHealthChecker.Error()},
+		{"redis up", Redis(&fakeRedis{}), ""},
+		{"redis down", Redis(&fakeRedis{err: refused}), "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.checker.Check(context.Background())
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestHealthSameKind(t *testing.T) {
+	orders := Named("mongodb-orders", Mongo(&fakePinger{}))
+	billing := Named("mongodb-billing", Mongo(&fakePinger{err: errors.New("connection refused")}))
+	// unnamed checkers of the same kind are cached apart
+	h := NewHealth(&Config{}, orders, billing, Redis(&fakeRedis{}), Redis(&fakeRedis{err: errors.New("connection refused")}))
+
+	for i := 0; i < 2; i++ {
+		report := h.Check(context.Background())
+		assert.Equal(t, "mongodb-orders", report.Checks[0].Name)
+		assert.Equal(t, StatusUp, report.Checks[0].Status)
+		assert.Equal(t, "mongodb-billing", report.Checks[1].Name)
+		assert.Equal(t, StatusDown, report.Checks[1].Status)
+		assert.Equal(t, StatusUp, report.Checks[2].Status)
+		assert.Equal(t, StatusDown, report.Checks[3].Status)
+	}
+}
@@ -0,0 +1,264 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+)
+
+// TopicDetail partitions, replication and configs of a topic
+type TopicDetail = sarama.TopicDetail
+
+// TopicMetadata partitions and replicas of a topic
+type TopicMetadata = sarama.TopicMetadata
+
+// PartitionLag offsets of a consumer group on a partition, Committed is -1
+// when the group has no offset for the partition yet.
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"`
+	Newest    int64  `json:"newest"`
+	Lag       int64  `json:"lag"`
+}
+
+// Admin manage the topics and inspect the consumer groups of the cluster
+type Admin interface {
+	CreateTopic(topic string, detail *TopicDetail) error
+	DeleteTopic(topic string) error
+	DescribeTopics(topics ...string) ([]*TopicMetadata, error)
+	// EnsureTopics create the missing topics with detail and return them,
+	// e.g. with RetryPolicy.Topics to create the retry and dead letter topics.
+	EnsureTopics(detail *TopicDetail, topics ...string) ([]string, error)
+	// AlterTopicConfig set entries of the topic config, the other entries
+	// are kept. It needs Config.Version 2.3.0 or later.
+	AlterTopicConfig(topic string, entries map[string]string) error
+	ListConsumerGroups() ([]string, error)
+	// Lag return the lag of group per partition of topics, every topic the
+	// group has committed offsets for when topics is empty.
+	Lag(group string, topics ...string) ([]*PartitionLag, error)
+	// ResetOffsets commit new offsets of group on every partition of topic,
+	// see ResetToEarliest, ResetToLatest, ResetToTime and ResetShiftBy. The
+	// group must be stopped.
+	ResetOffsets(group, topic string, reset OffsetReset) ([]*OffsetChange, error)
+	// Ping refresh the cluster metadata, an error when no broker answers
+	Ping() error
+	Close() error
+}
+
+type admin struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+// CreateAdmin return cluster admin connected with the brokers, version and
+// security of cfg
+func CreateAdmin(cfg *Config) (Admin, error) {
+	config, err := newAdminConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewClient(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Sarama client: %w", err)
+	}
+	clusterAdmin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to start Sarama cluster admin: %w", err)
+	}
+	return &admin{client: client, admin: clusterAdmin}, nil
+}
+
+// newAdminConfig build the sarama configuration of the admin client
+func newAdminConfig(cfg *Config) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	problems := &ConfigError{}
+
+	if cfg.Version == "" {
+		cfg.Version = defaultVersion
+	}
+
+	version, err := sarama.ParseKafkaVersion(cfg.Version)
+	if err != nil {
+		problems.add("version", cfg.Version, "is not a kafka version")
+	}
+	config.Version = version
+	config.ClientID = cfg.ClientID
+
+	applySecurity(cfg, config, problems)
+	applyMetrics(cfg, config)
+
+	return config, problems.err()
+}
+
+func (k *admin) CreateTopic(topic string, detail *TopicDetail) error {
+	if err := k.admin.CreateTopic(topic, detail, false); err != nil {
+		return fmt.Errorf("create topic %s got: %w", topic, err)
+	}
+	return nil
+}
+
+func (k *admin) DeleteTopic(topic string) error {
+	if err := k.admin.DeleteTopic(topic); err != nil {
+		return fmt.Errorf("delete topic %s got: %w", topic, err)
+	}
+	return nil
+}
+
+func (k *admin) DescribeTopics(topics ...string) ([]*TopicMetadata, error) {
+	metadata, err := k.admin.DescribeTopics(topics)
+	if err != nil {
+		return nil, fmt.Errorf("describe topics %v got: %w", topics, err)
+	}
+	return metadata, nil
+}
+
+func (k *admin) EnsureTopics(detail *TopicDetail, topics ...string) ([]string, error) {
+	existing, err := k.admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("list topics got: %w", err)
+	}
+
+	created := make([]string, 0)
+	for _, topic := range topics {
+		if _, ok := existing[topic]; ok {
+			continue
+		}
+		err := k.admin.CreateTopic(topic, detail, false)
+		// created by another deploy in the meantime
+		if topicExists(err) {
+			continue
+		}
+		if err != nil {
+			return created, fmt.Errorf("create topic %s got: %w", topic, err)
+		}
+		logger.Info(logger.SetMessageFormat("[kafka] created topic %s", topic))
+		created = append(created, topic)
+	}
+	return created, nil
+}
+
+// topicExists tell whether err is a create topic error of an existing topic
+func topicExists(err error) bool {
+	var topicErr *sarama.TopicError
+	if errors.As(err, &topicErr) {
+		return topicErr.Err == sarama.ErrTopicAlreadyExists
+	}
+	return errors.Is(err, sarama.ErrTopicAlreadyExists)
+}
+
+func (k *admin) AlterTopicConfig(topic string, entries map[string]string) error {
+	param := make(map[string]sarama.IncrementalAlterConfigsEntry, len(entries))
+	for name, value := range entries {
+		value := value
+		param[name] = sarama.IncrementalAlterConfigsEntry{
+			Operation: sarama.IncrementalAlterConfigsOperationSet,
+			Value:     &value,
+		}
+	}
+	if err := k.admin.IncrementalAlterConfig(sarama.TopicResource, topic, param, false); err != nil {
+		return fmt.Errorf("alter topic %s config got: %w", topic, err)
+	}
+	return nil
+}
+
+func (k *admin) ListConsumerGroups() ([]string, error) {
+	groups, err := k.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("list consumer groups got: %w", err)
+	}
+	result := make([]string, 0, len(groups))
+	for group := range groups {
+		result = append(result, group)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (k *admin) Lag(group string, topics ...string) ([]*PartitionLag, error) {
+	var partitions map[string][]int32
+	if len(topics) > 0 {
+		partitions = make(map[string][]int32, len(topics))
+		for _, topic := range topics {
+			ids, err := k.client.Partitions(topic)
+			if err != nil {
+				return nil, fmt.Errorf("partitions of topic %s got: %w", topic, err)
+			}
+			partitions[topic] = ids
+		}
+	}
+
+	// a nil partitions map fetch every committed offset of the group
+	offsets, err := k.admin.ListConsumerGroupOffsets(group, partitions)
+	if err != nil {
+		return nil, fmt.Errorf("offsets of group %s got: %w", group, err)
+	}
+
+	result := make([]*PartitionLag, 0)
+	for topic, blocks := range offsets.Blocks {
+		for partition, block := range blocks {
+			if block.Err != sarama.ErrNoError {
+				return nil, fmt.Errorf("offset of group %s topic %s partition %d got: %w", group, topic, partition, block.Err)
+			}
+			newest, err := k.client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("newest offset of topic %s partition %d got: %w", topic, partition, err)
+			}
+			oldest := int64(0)
+			if block.Offset < 0 {
+				oldest, err = k.client.GetOffset(topic, partition, sarama.OffsetOldest)
+				if err != nil {
+					return nil, fmt.Errorf("oldest offset of topic %s partition %d got: %w", topic, partition, err)
+				}
+			}
+			result = append(result, partitionLag(topic, partition, block.Offset, oldest, newest))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Topic != result[j].Topic {
+			return result[i].Topic < result[j].Topic
+		}
+		return result[i].Partition < result[j].Partition
+	})
+	return result, nil
+}
+
+// partitionLag compute the lag of a committed offset, a partition without
+// committed offset lag by all the messages from oldest.
+func partitionLag(topic string, partition int32, committed, oldest, newest int64) *PartitionLag {
+	start := committed
+	if committed < 0 {
+		start = oldest
+	}
+	lag := newest - start
+	if lag < 0 {
+		lag = 0
+	}
+	return &PartitionLag{
+		Topic:     topic,
+		Partition: partition,
+		Committed: committed,
+		Newest:    newest,
+		Lag:       lag,
+	}
+}
+
+func (k *admin) Ping() error {
+	if err := k.client.RefreshMetadata(); err != nil {
+		return fmt.Errorf("refresh metadata got: %w", err)
+	}
+	if _, err := k.client.Controller(); err != nil {
+		return fmt.Errorf("controller got: %w", err)
+	}
+	return nil
+}
+
+func (k *admin) Close() error {
+	return k.admin.Close()
+}
@@ -0,0 +1,129 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionLag(t *testing.T) {
+	assert.Equal(t, int64(40), partitionLag("orders", 0, 60, 0, 100).Lag)
+	// no committed offset, every retained message is pending
+	assert.Equal(t, int64(70), partitionLag("orders", 1, -1, 30, 100).Lag)
+	// committed past a truncated partition
+	assert.Equal(t, int64(0), partitionLag("orders", 2, 120, 0, 100).Lag)
+}
+
+func TestCreateAdminConfigError(t *testing.T) {
+	_, err := CreateAdmin(&Config{Version: "x.y"})
+	assert.IsType(t, &ConfigError{}, err)
+}
+
+// mockAdmin admin of a single mock broker leading the partitions 0 and 1 of
+// orders and coordinating the group billing, handlers add to the responses.
+// The admin speaks kafka 2.3.0 to alter configs incrementally.
+func mockAdmin(t *testing.T, handlers map[string]sarama.MockResponse) (*sarama.MockBroker, Admin) {
+	broker := sarama.NewMockBroker(t, 1)
+	responses := map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "billing", broker),
+	}
+	for name, response := range handlers {
+		responses[name] = response
+	}
+	broker.SetHandlerByMap(responses)
+
+	a, err := CreateAdmin(&Config{Brokers: []string{broker.Addr()}, Version: "2.3.0"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		_ = a.Close()
+		broker.Close()
+	})
+	return broker, a
+}
+
+func TestAdminTopics(t *testing.T) {
+	broker, admin := mockAdmin(t, map[string]sarama.MockResponse{
+		"CreateTopicsRequest":    sarama.NewMockCreateTopicsResponse(t),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+	})
+
+	assert.NoError(t, admin.CreateTopic("invoices", &TopicDetail{NumPartitions: 3, ReplicationFactor: 1}))
+
+	metadata, err := admin.DescribeTopics("orders")
+	assert.NoError(t, err)
+	if assert.Len(t, metadata, 1) {
+		assert.Equal(t, "orders", metadata[0].Name)
+		assert.Len(t, metadata[0].Partitions, 2)
+	}
+
+	// orders exists, only the dead letter topic is created
+	created, err := admin.EnsureTopics(&TopicDetail{NumPartitions: 2, ReplicationFactor: 1}, "orders", "orders.billing.dlq")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orders.billing.dlq"}, created)
+
+	var requested []string
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.CreateTopicsRequest); ok {
+			for topic, detail := range req.TopicDetails {
+				requested = append(requested, topic)
+				assert.Equal(t, int16(1), detail.ReplicationFactor)
+			}
+		}
+	}
+	assert.Equal(t, []string{"invoices", "orders.billing.dlq"}, requested)
+}
+
+func TestAdminAlterTopicConfig(t *testing.T) {
+	broker, admin := mockAdmin(t, map[string]sarama.MockResponse{
+		"IncrementalAlterConfigsRequest": sarama.NewMockIncrementalAlterConfigsResponse(t),
+	})
+
+	assert.NoError(t, admin.AlterTopicConfig("orders", map[string]string{"retention.ms": "3600000"}))
+
+	altered := map[string]string{}
+	for _, rr := range broker.History() {
+		req, ok := rr.Request.(*sarama.IncrementalAlterConfigsRequest)
+		if !ok {
+			continue
+		}
+		for _, resource := range req.Resources {
+			assert.Equal(t, "orders", resource.Name)
+			for name, entry := range resource.ConfigEntries {
+				assert.Equal(t, sarama.IncrementalAlterConfigsOperationSet, entry.Operation)
+				altered[name] = *entry.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]string{"retention.ms": "3600000"}, altered)
+}
+
+func TestAdminLag(t *testing.T) {
+	offsets := sarama.NewMockOffsetResponse(t)
+	for _, partition := range []int32{0, 1} {
+		offsets.SetOffset("orders", partition, sarama.OffsetOldest, 10).
+			SetOffset("orders", partition, sarama.OffsetNewest, 100)
+	}
+	_, admin := mockAdmin(t, map[string]sarama.MockResponse{
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("billing", "orders", 0, 60, "", sarama.ErrNoError).
+			SetOffset("billing", "orders", 1, -1, "", sarama.ErrNoError),
+		"OffsetRequest": offsets,
+	})
+
+	lag, err := admin.Lag("billing", "orders")
+	assert.NoError(t, err)
+	if assert.Len(t, lag, 2) {
+		assert.Equal(t, &PartitionLag{Topic: "orders", Partition: 0, Committed: 60, Newest: 100, Lag: 40}, lag[0])
+		// no committed offset, lag from the oldest retained message
+		assert.Equal(t, &PartitionLag{Topic: "orders", Partition: 1, Committed: -1, Newest: 100, Lag: 90}, lag[1])
+	}
+}
@@ -0,0 +1,163 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+)
+
+// AsyncProducer publish messages without waiting for the broker
+type AsyncProducer interface {
+	Producer
+	// Results report the outcome of every published message, it must be
+	// drained otherwise publishing blocks once its buffer is full. It is
+	// closed by Close.
+	Results() <-chan *PublishResult
+}
+
+// PublishResult outcome of a message published by an AsyncProducer
+type PublishResult struct {
+	Message   *MessageContext
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
+type asyncProducer struct {
+	config   *sarama.Config
+	brokers  []string
+	producer sarama.AsyncProducer
+	results  chan *PublishResult
+	codecs   codecs
+	metrics  Metrics
+	wg       sync.WaitGroup
+}
+
+// CreateAsyncProducer return a producer batching messages in the background,
+// messages are sent by ProducerConfig linger, batch size and compression.
+func CreateAsyncProducer(cfg *Config) (AsyncProducer, error) {
+	m, err := newAsyncProducer(cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewAsyncProducer return a producer batching messages in the background,
+// see CreateAsyncProducer.
+//
+// Deprecated: NewAsyncProducer exit the process on error, use CreateAsyncProducer.
+func NewAsyncProducer(cfg *Config) AsyncProducer {
+	m, err := CreateAsyncProducer(cfg)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	return m
+}
+
+func newAsyncProducer(cfg *Config, withResults bool) (*asyncProducer, error) {
+	config, err := newProducerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewAsyncProducer(cfg.Brokers, config)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Sarama async producer: %w", err)
+	}
+
+	m := wrapAsyncProducer(producer, config, withResults, metricsOf(cfg))
+	m.brokers = cfg.Brokers
+	m.codecs = cfg.Codecs
+	return m, nil
+}
+
+// wrapAsyncProducer start draining the results of producer
+func wrapAsyncProducer(producer sarama.AsyncProducer, config *sarama.Config, withResults bool, metrics Metrics) *asyncProducer {
+	m := &asyncProducer{
+		config:   config,
+		producer: producer,
+		metrics:  metrics,
+	}
+	if withResults {
+		m.results = make(chan *PublishResult, config.ChannelBufferSize)
+	}
+
+	m.wg.Add(2)
+	go m.successes()
+	go m.errors()
+
+	return m
+}
+
+// Publish queue msg, it returns once the message is handed to sarama or
+// ctx is done.
+func (k *asyncProducer) Publish(ctx context.Context, msg *MessageContext) error {
+	param, err := producerMessage(ctx, msg, k.codecs.of(msg))
+	if err != nil {
+		return err
+	}
+	select {
+	case k.producer.Input() <- param:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("publish to topic: %s, id %v, got:%s ", msg.Topic, msg.LogId, ctx.Err().Error())
+	}
+}
+
+// PublishBatch queue every message, the results are reported one by one.
+func (k *asyncProducer) PublishBatch(ctx context.Context, msgs []*MessageContext) error {
+	for _, msg := range msgs {
+		if err := k.Publish(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *asyncProducer) Results() <-chan *PublishResult {
+	return k.results
+}
+
+// Close flush the pending messages, wait for their results and close Results
+func (k *asyncProducer) Close() error {
+	err := k.producer.Close()
+	k.wg.Wait()
+	if k.results != nil {
+		close(k.results)
+	}
+	return err
+}
+
+func (k *asyncProducer) successes() {
+	defer k.wg.Done()
+	for m := range k.producer.Successes() {
+		k.metrics.Produced(m.Topic, nil)
+		msg, _ := m.Metadata.(*MessageContext)
+		if msg != nil && msg.Verbose {
+			logger.Info(fmt.Sprintf("publish to topic: %s,  partition: %d, offset: %d", m.Topic, m.Partition, m.Offset), logger.SetField("msg", msg.Value))
+		}
+		if k.results != nil {
+			k.results <- &PublishResult{Message: msg, Partition: m.Partition, Offset: m.Offset}
+		}
+	}
+}
+
+func (k *asyncProducer) errors() {
+	defer k.wg.Done()
+	for e := range k.producer.Errors() {
+		k.metrics.Produced(e.Msg.Topic, e.Err)
+		msg, _ := e.Msg.Metadata.(*MessageContext)
+		err := fmt.Errorf("publish to topic: %s, partition %d, got:%s ", e.Msg.Topic, e.Msg.Partition, e.Err.Error())
+		if k.results == nil {
+			logger.Error(err.Error())
+			continue
+		}
+		k.results <- &PublishResult{Message: msg, Partition: e.Msg.Partition, Offset: e.Msg.Offset, Err: err}
+	}
+}
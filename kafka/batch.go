@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	defaultBatchSize   = 100
+	defaultBatchWindow = time.Second
+)
+
+// BatchProcessorFunc process the messages of a claim at once, e.g. to write
+// them with a single elastic.Client.BulkRequest. The offsets are marked once
+// it returns nil, on error the messages follow the RetryPolicy of the
+// ConsumerContext one by one. Without one the batch is processed again until
+// it succeeds or the session ends.
+type BatchProcessorFunc func([]*MessageDecoder) error
+
+// consumeBatch collect the claim messages by count or time window and hand
+// them to the batch processor
+func (c *consumerHandler) consumeBatch(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	batch := make([]*sarama.ConsumerMessage, 0, c.batchSize)
+	timer := time.NewTimer(c.batchWindow)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	defer timer.Stop()
+
+	flush := func() bool {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if len(batch) == 0 {
+			return true
+		}
+		ok := c.processBatch(session, batch)
+		c.control.done(len(batch))
+		batch = batch[:0]
+		return ok
+	}
+	// the collected messages left when the session ends are no longer in flight
+	defer func() {
+		c.control.done(len(batch))
+	}()
+
+	for {
+		// a paused claim stop taking messages, the collected batch is
+		// still flushed by the window
+		paused, changed := c.control.watch(claim.Topic(), claim.Partition())
+		messages := claim.Messages()
+		if paused {
+			messages = nil
+		}
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				flush()
+				return nil
+			}
+			c.metrics.Consumed(c.groupID, msg.Topic, msg.Partition, lag(claim, msg))
+			if !c.due(session, msg) {
+				return nil
+			}
+			c.control.received(1)
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer.Reset(c.batchWindow)
+			}
+			if len(batch) >= c.batchSize && !flush() {
+				return nil
+			}
+		case <-changed:
+		case <-timer.C:
+			if !flush() {
+				return nil
+			}
+		case <-session.Context().Done():
+			// the collected batch is not marked and will be consumed again
+			return nil
+		}
+	}
+}
+
+// processBatch run the batch processor and mark the batch once it is done,
+// false means the session ended before. The marked offsets are committed by
+// the sarama auto commit interval and when the session ends, not per batch.
+func (c *consumerHandler) processBatch(session sarama.ConsumerGroupSession, batch []*sarama.ConsumerMessage) bool {
+	last := batch[len(batch)-1]
+	decoders := make([]*MessageDecoder, 0, len(batch))
+	bodies := make([]*BodyStateful, 0, len(batch))
+	for _, msg := range batch {
+		msg := msg
+		decoder, body, err := c.decode(session.Context(), msg, func() { session.MarkMessage(msg, "") })
+		if err != nil {
+			if !c.poison(session.Context(), msg, err) {
+				return false
+			}
+			continue
+		}
+		defer decoder.finish()
+		decoders = append(decoders, decoder)
+		bodies = append(bodies, body)
+	}
+	if len(decoders) == 0 {
+		session.MarkMessage(last, "")
+		return true
+	}
+
+	for {
+		started := time.Now()
+		err := c.batchProcessor(decoders)
+		c.metrics.Handled(c.groupID, last.Topic, time.Since(started), err)
+		if err == nil {
+			break
+		}
+		if c.retry != nil {
+			for i, decoder := range decoders {
+				if !c.republish(session.Context(), c.origin(decoder.Topic), decoder, bodies[i], err) {
+					return false
+				}
+			}
+			break
+		}
+		// marking a failed batch would lose its messages, it is processed
+		// again instead
+		logger.Error(logger.SetMessageFormat("[consumer] topic %s partition %d offset %d-%d batch processing error %s",
+			last.Topic, last.Partition, batch[0].Offset, last.Offset, err.Error()))
+		if !wait(session.Context(), republishBackoff) {
+			return false
+		}
+	}
+
+	session.MarkMessage(last, "")
+	return true
+}
@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchClaim claim of partition 0 of orders fed by its channel
+type batchClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *batchClaim) Topic() string                            { return "orders" }
+func (c *batchClaim) Partition() int32                         { return 0 }
+func (c *batchClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *batchClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// batchSession session recording the marked offset
+type batchSession struct {
+	fakeSession
+	ctx       context.Context
+	mu        sync.Mutex
+	marked    int64
+	committed int
+}
+
+func (s *batchSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *batchSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = msg.Offset + 1
+}
+
+func (s *batchSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed++
+}
+
+func (s *batchSession) offset() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.marked
+}
+
+func (s *batchSession) commits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.committed
+}
+
+// runBatch consume claim with a batch handler until the returned stop is called
+func runBatch(t *testing.T, ctx *ConsumerContext) (*batchClaim, *batchSession, func()) {
+	sessionCtx, cancel := context.WithCancel(context.Background())
+	claim := &batchClaim{messages: make(chan *sarama.ConsumerMessage, 10)}
+	session := &batchSession{ctx: sessionCtx}
+	handler := newConsumerHandler(ctx, true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, handler.consumeBatch(session, claim))
+	}()
+	return claim, session, func() {
+		cancel()
+		<-done
+	}
+}
+
+func batchMessage(offset int64) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{Topic: "orders", Offset: offset, Value: []byte(`{"payload":{"id":"a"}}`)}
+}
+
+func TestConsumeBatchFlushOnSize(t *testing.T) {
+	batches := make(chan int, 10)
+	claim, session, stop := runBatch(t, &ConsumerContext{
+		BatchHandler: func(msgs []*MessageDecoder) error {
+			batches <- len(msgs)
+			return nil
+		},
+		BatchSize:   2,
+		BatchWindow: time.Hour,
+	})
+	defer stop()
+
+	for offset := int64(0); offset < 4; offset++ {
+		claim.messages <- batchMessage(offset)
+	}
+	assert.Equal(t, 2, <-batches)
+	assert.Equal(t, 2, <-batches)
+	assert.Eventually(t, func() bool { return session.offset() == 4 }, time.Second, time.Millisecond)
+	assert.Zero(t, session.commits(), "offsets are committed by the auto commit, not per batch")
+}
+
+func TestConsumeBatchFlushOnWindow(t *testing.T) {
+	batches := make(chan int, 10)
+	claim, session, stop := runBatch(t, &ConsumerContext{
+		BatchHandler: func(msgs []*MessageDecoder) error {
+			batches <- len(msgs)
+			return nil
+		},
+		BatchSize:   10,
+		BatchWindow: 20 * time.Millisecond,
+	})
+	defer stop()
+
+	started := time.Now()
+	claim.messages <- batchMessage(0)
+	assert.Equal(t, 1, <-batches)
+	assert.GreaterOrEqual(t, time.Since(started), 20*time.Millisecond)
+	assert.Eventually(t, func() bool { return session.offset() == 1 }, time.Second, time.Millisecond)
+}
+
+func TestConsumeBatchError(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	claim, session, stop := runBatch(t, &ConsumerContext{
+		BatchHandler: func(msgs []*MessageDecoder) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if calls == 1 {
+				return errors.New("bulk rejected")
+			}
+			return nil
+		},
+		BatchSize:   2,
+		BatchWindow: time.Hour,
+	})
+	defer stop()
+
+	claim.messages <- batchMessage(0)
+	claim.messages <- batchMessage(1)
+
+	// the failed batch is not marked, it is processed again
+	time.Sleep(republishBackoff / 2)
+	assert.Zero(t, session.offset())
+	assert.Eventually(t, func() bool { return session.offset() == 2 }, 2*republishBackoff, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 2, calls)
+	mu.Unlock()
+}
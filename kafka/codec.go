@@ -0,0 +1,240 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec serialize the record value of messages. Topics use EnvelopeCodec
+// unless set otherwise with Config.Codecs, or MessageContext.Codec for a
+// single message.
+type Codec interface {
+	// Encode return the record value of body, a []byte or json.RawMessage
+	// Body is an already serialized payload and is written as is.
+	Encode(body *BodyStateful) ([]byte, error)
+	// Decode split a record value into the payload and the envelope fields,
+	// codecs without envelope return an empty BodyStateful.
+	Decode(data []byte) (payload []byte, body *BodyStateful, err error)
+	// Unmarshal decode a payload into out, see MessageDecoder.Cast
+	Unmarshal(payload []byte, out interface{}) error
+}
+
+var (
+	// EnvelopeCodec wrap the payload in the BodyStateful JSON envelope
+	EnvelopeCodec Codec = envelopeCodec{}
+	// RawCodec write []byte or string payloads as is
+	RawCodec Codec = rawCodec{}
+	// JSONCodec write the payload as plain JSON without envelope
+	JSONCodec Codec = jsonCodec{}
+	// ProtobufCodec write proto.Message payloads in protobuf wire format
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// codecs codec per topic
+type codecs map[string]Codec
+
+// get return the codec of topic, EnvelopeCodec by default
+func (c codecs) get(topic string) Codec {
+	if codec, ok := c[topic]; ok && codec != nil {
+		return codec
+	}
+	return EnvelopeCodec
+}
+
+// of return the codec of msg
+func (c codecs) of(msg *MessageContext) Codec {
+	if msg.Codec != nil {
+		return msg.Codec
+	}
+	return c.get(msg.Topic)
+}
+
+// rawPayload return the payload when it is already serialized
+func rawPayload(v interface{}) ([]byte, bool) {
+	switch p := v.(type) {
+	case []byte:
+		return p, true
+	case json.RawMessage:
+		return p, true
+	}
+	return nil, false
+}
+
+// envelope BodyStateful keeping the payload serialized
+type envelope struct {
+	Body    json.RawMessage `json:"payload"`
+	Message string          `json:"message"`
+	Error   string          `json:"error,omitempty"`
+	Source  *SourceData     `json:"source,omitempty"`
+}
+
+type envelopeCodec struct{}
+
+func (envelopeCodec) Encode(body *BodyStateful) ([]byte, error) {
+	if raw, ok := rawPayload(body.Body); ok {
+		return json.Marshal(&envelope{
+			Body:    raw,
+			Message: body.Message,
+			Error:   body.Error,
+			Source:  body.Source,
+		})
+	}
+	return json.Marshal(body)
+}
+
+func (envelopeCodec) Decode(data []byte) ([]byte, *BodyStateful, error) {
+	env := &envelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil, &BodyStateful{}, fmt.Errorf("decode message envelope got: %w", err)
+	}
+	payload := []byte(env.Body)
+	if len(payload) == 0 {
+		payload = []byte("null")
+	}
+	return payload, &BodyStateful{
+		Message: env.Message,
+		Error:   env.Error,
+		Source:  env.Source,
+	}, nil
+}
+
+func (envelopeCodec) Unmarshal(payload []byte, out interface{}) error {
+	return json.Unmarshal(payload, out)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Encode(body *BodyStateful) ([]byte, error) {
+	if raw, ok := rawPayload(body.Body); ok {
+		return raw, nil
+	}
+	if s, ok := body.Body.(string); ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("raw codec payload must be []byte or string, got: %T", body.Body)
+}
+
+func (rawCodec) Decode(data []byte) ([]byte, *BodyStateful, error) {
+	return data, &BodyStateful{}, nil
+}
+
+func (rawCodec) Unmarshal(payload []byte, out interface{}) error {
+	switch p := out.(type) {
+	case *[]byte:
+		*p = payload
+	case *string:
+		*p = string(payload)
+	default:
+		return fmt.Errorf("raw codec output must be *[]byte or *string, got: %T", out)
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(body *BodyStateful) ([]byte, error) {
+	if raw, ok := rawPayload(body.Body); ok {
+		return raw, nil
+	}
+	return json.Marshal(body.Body)
+}
+
+func (jsonCodec) Decode(data []byte) ([]byte, *BodyStateful, error) {
+	return data, &BodyStateful{}, nil
+}
+
+func (jsonCodec) Unmarshal(payload []byte, out interface{}) error {
+	return json.Unmarshal(payload, out)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Encode(body *BodyStateful) ([]byte, error) {
+	if raw, ok := rawPayload(body.Body); ok {
+		return raw, nil
+	}
+	msg, ok := body.Body.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec payload must be proto.Message, got: %T", body.Body)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Decode(data []byte) ([]byte, *BodyStateful, error) {
+	return data, &BodyStateful{}, nil
+}
+
+func (protobufCodec) Unmarshal(payload []byte, out interface{}) error {
+	msg, ok := out.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec output must be proto.Message, got: %T", out)
+	}
+	return proto.Unmarshal(payload, msg)
+}
+
+// AvroSchema adapter of an avro library schema, e.g. hamba/avro:
+//
+//	schema := avro.MustParse(`{"type":"record", ...}`)
+//	codec := kafka.NewAvroCodec(avroSchema{schema})
+//
+// with avroSchema calling avro.Marshal and avro.Unmarshal of its schema.
+type AvroSchema interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type avroCodec struct {
+	schema AvroSchema
+}
+
+// NewAvroCodec return codec writing payloads in avro binary encoding of schema
+func NewAvroCodec(schema AvroSchema) Codec {
+	return &avroCodec{schema: schema}
+}
+
+func (c *avroCodec) Encode(body *BodyStateful) ([]byte, error) {
+	if raw, ok := rawPayload(body.Body); ok {
+		return raw, nil
+	}
+	return c.schema.Marshal(body.Body)
+}
+
+func (c *avroCodec) Decode(data []byte) ([]byte, *BodyStateful, error) {
+	return data, &BodyStateful{}, nil
+}
+
+func (c *avroCodec) Unmarshal(payload []byte, out interface{}) error {
+	return c.schema.Unmarshal(payload, out)
+}
+
+// retryHeaders add the retry state of source to headers, codecs without
+// envelope carry it in headers only.
+func retryHeaders(headers map[string]string, source *SourceData, cause string) map[string]string {
+	result := make(map[string]string, len(headers)+3)
+	for k, v := range headers {
+		result[k] = v
+	}
+	result[HeaderRetryTopic] = source.Topic
+	result[HeaderRetryAttempt] = strconv.Itoa(source.Attempt)
+	result[HeaderRetryError] = cause
+	return result
+}
+
+// sourceFromHeaders restore the retry state of a message without envelope
+func sourceFromHeaders(body *BodyStateful, headers map[string]string) {
+	if body.Source != nil {
+		return
+	}
+	attempt, err := strconv.Atoi(headers[HeaderRetryAttempt])
+	if err != nil {
+		return
+	}
+	body.Source = &SourceData{
+		Topic:   headers[HeaderRetryTopic],
+		Attempt: attempt,
+	}
+	body.Error = headers[HeaderRetryError]
+}
@@ -0,0 +1,83 @@
+package kafka
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestEnvelopeCodec(t *testing.T) {
+	data, err := EnvelopeCodec.Encode(&BodyStateful{
+		Body:    map[string]int{"id": 1},
+		Message: "created",
+		Source:  &SourceData{Service: "order"},
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"payload":{"id":1},"message":"created","source":{"service":"order"}}`, string(data))
+
+	payload, body, err := EnvelopeCodec.Decode(data)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":1}`, string(payload))
+	assert.Equal(t, "created", body.Message)
+	assert.Equal(t, "order", body.Source.Service)
+
+	// a retried payload is kept as is
+	again, err := EnvelopeCodec.Encode(&BodyStateful{Body: json.RawMessage(payload)})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"payload":{"id":1},"message":""}`, string(again))
+
+	_, _, err = EnvelopeCodec.Decode([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestJSONCodec(t *testing.T) {
+	data, err := JSONCodec.Encode(&BodyStateful{Body: map[string]int{"id": 1}, Message: "ignored"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":1}`, string(data))
+
+	payload, body, err := JSONCodec.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, data, payload)
+	assert.Nil(t, body.Source)
+}
+
+func TestRawCodec(t *testing.T) {
+	data, err := RawCodec.Encode(&BodyStateful{Body: "hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	_, err = RawCodec.Encode(&BodyStateful{Body: 1})
+	assert.Error(t, err)
+
+	var out string
+	assert.NoError(t, RawCodec.Unmarshal(data, &out))
+	assert.Equal(t, "hello", out)
+}
+
+func TestProtobufCodec(t *testing.T) {
+	data, err := ProtobufCodec.Encode(&BodyStateful{Body: wrapperspb.String("hello")})
+	assert.NoError(t, err)
+
+	out := &wrapperspb.StringValue{}
+	assert.NoError(t, ProtobufCodec.Unmarshal(data, out))
+	assert.Equal(t, "hello", out.GetValue())
+
+	_, err = ProtobufCodec.Encode(&BodyStateful{Body: "hello"})
+	assert.Error(t, err)
+}
+
+func TestRetryHeaders(t *testing.T) {
+	headers := retryHeaders(map[string]string{HeaderRequestID: "abc"}, &SourceData{Topic: "orders", Attempt: 2}, "boom")
+	assert.Equal(t, "abc", headers[HeaderRequestID])
+
+	body := &BodyStateful{}
+	sourceFromHeaders(body, headers)
+	assert.Equal(t, &SourceData{Topic: "orders", Attempt: 2}, body.Source)
+	assert.Equal(t, "boom", body.Error)
+
+	body = &BodyStateful{}
+	sourceFromHeaders(body, map[string]string{})
+	assert.Nil(t, body.Source)
+}
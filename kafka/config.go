@@ -1,5 +1,7 @@
 package kafka
 
+import "github.com/Shopify/sarama"
+
 const (
 	defaultVersion = "2.1.1"
 )
@@ -22,6 +24,14 @@ type Config struct {
 	// in the background while user code is working, greatly improving throughput.
 	// Defaults to 256.
 	ChannelBufferSize int `json:"channel_buffer_size" yaml:"channel_buffer_size"`
+	// SchemaRegistry connection of the Confluent Schema Registry, see NewSchemaRegistry.
+	SchemaRegistry SchemaRegistryConfig `json:"schema_registry" yaml:"schema_registry"`
+	// Codecs serialize the messages per topic for the producers and the
+	// consumers, topics without a codec use EnvelopeCodec.
+	Codecs map[string]Codec `json:"-" yaml:"-"`
+	// Metrics record the activity of the producers and consumers, see
+	// NewPrometheusMetrics. Nothing is recorded when nil.
+	Metrics Metrics `json:"-" yaml:"-"`
 }
 
 type ProducerConfig struct {
@@ -47,39 +57,78 @@ type ProducerConfig struct {
 	// (defaults to hashing the message key). Similar to the `partitioner.class`
 	// setting for the JVM producer.
 	PartitionStrategy string `json:"partition_strategy" yaml:"partition_strategy"`
+
+	// Async publish without waiting for the broker, see CreateAsyncProducer.
+	Async bool `json:"async" yaml:"async"`
+	// The best-effort frequency of flushes. Equivalent to `queue.buffering.max.ms`
+	// setting of JVM producer.
+	LingerMillisecond int `json:"linger_millisecond" yaml:"linger_millisecond"`
+	// The best-effort number of messages needed to trigger a flush.
+	BatchSize int `json:"batch_size" yaml:"batch_size"`
+	// The best-effort number of bytes needed to trigger a flush.
+	BatchBytes int `json:"batch_bytes" yaml:"batch_bytes"`
+	// The type of compression to use on messages
+	// Possible values: none, gzip, snappy, lz4, zstd (defaults to none).
+	// zstd requires Version 2.1.0 or later.
+	Compression string `json:"compression" yaml:"compression"`
+
+	// TransactionalID identify a transactional producer through restarts,
+	// it must be unique per producer instance. See CreateTransactionalProducer.
+	TransactionalID string `json:"transactional_id" yaml:"transactional_id"`
+	// Amount of time a transaction can remain unresolved (defaults to 60 seconds).
+	TransactionTimeoutSecond int `json:"transaction_timeout_second" yaml:"transaction_timeout_second"`
 }
 
 type ConsumerConfig struct {
 	// Minimum is 10s
-	SessionTimeoutSecond int    `json:"session_timeout_second" yaml:"session_timeout_second"`
-	OffsetInitial        int64  `json:"offset_initial" yaml:"offset_initial"`
-	HeartbeatInterval    int    `json:"heartbeat_interval" yaml:"heartbeat_interval"`
-	RebalanceStrategy    string `json:"rebalance_strategy" yaml:"rebalance_strategy"`
-	AutoCommit           bool   `json:"auto_commit" yaml:"auto_commit"`
-	IsolationLevel       int8   `json:"isolation_level" yaml:"isolation_level"`
+	SessionTimeoutSecond int `json:"session_timeout_second" yaml:"session_timeout_second"`
+	// InitialOffset where a group without committed offset start, oldest
+	// or newest (defaults to newest). It takes precedence over OffsetInitial.
+	InitialOffset string `json:"initial_offset" yaml:"initial_offset"`
+	// OffsetInitial -1 newest or -2 oldest, see InitialOffset
+	OffsetInitial     int64  `json:"offset_initial" yaml:"offset_initial"`
+	HeartbeatInterval int    `json:"heartbeat_interval" yaml:"heartbeat_interval"`
+	RebalanceStrategy string `json:"rebalance_strategy" yaml:"rebalance_strategy"`
+	AutoCommit        bool   `json:"auto_commit" yaml:"auto_commit"`
+	IsolationLevel    int8   `json:"isolation_level" yaml:"isolation_level"`
 }
 
 type SASL struct {
 	// Whether or not to use SASL authentication when connecting to the broker
-	// (defaults to false).
+	// (defaults to false). It does not enable TLS, set TLS.Enable for SASL_SSL.
 	Enable bool `json:"enable" yaml:"enable"`
 	// SASLMechanism is the name of the enabled SASL mechanism.
-	// Possible values: OAUTHBEARER, PLAIN (defaults to PLAIN).
+	// Possible values: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512, OAUTHBEARER
+	// (defaults to PLAIN).
 	Mechanism string `json:"mechanism" yaml:"mechanism"`
 	// Version is the SASL Protocol Version to use
 	// Kafka > 1.x should use V1, except on Azure EventHub which use V0
 	Version int16 `json:"version" yaml:"version"`
-	// Whether or not to send the Kafka SASL handshake first if enabled
-	// (defaults to true). You should only set this to false if you're using
-	// a non-Kafka SASL proxy.
+	// Deprecated: the handshake is always sent unless DisableHandshake is set.
 	Handshake bool `json:"handshake" yaml:"handshake"`
+	// Whether or not to skip the Kafka SASL handshake, only set it if you're
+	// using a non-Kafka SASL proxy.
+	DisableHandshake bool `json:"disable_handshake" yaml:"disable_handshake"`
 	// User is the authentication identity (authcid) to present for
 	// SASL/PLAIN or SASL/SCRAM authentication
 	User string `json:"user" yaml:"user"`
 	// Password for SASL/PLAIN authentication
 	Password string `json:"password" yaml:"password"`
 	// authz id used for SASL/SCRAM authentication
+	SCRAMAuthzID string `json:"scram_authz_id" yaml:"scram_authz_id"`
+	// TokenProvider return the OAUTHBEARER tokens, it is required by the
+	// OAUTHBEARER mechanism. See TokenProviderFunc.
+	TokenProvider sarama.AccessTokenProvider `json:"-" yaml:"-"`
+}
 
+type SchemaRegistryConfig struct {
+	// URL of the registry, e.g. http://localhost:8081
+	URL string `json:"url" yaml:"url"`
+	// User and Password of the registry basic authentication, optional
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	// TimeoutSecond of a registry request (defaults to 5 seconds).
+	TimeoutSecond int `json:"timeout_second" yaml:"timeout_second"`
 }
 
 type TLS struct {
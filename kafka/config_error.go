@@ -0,0 +1,50 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError invalid value of a Config field, Field is its yaml path
+type FieldError struct {
+	Field   string
+	Value   interface{}
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %s, got: %v", e.Field, e.Message, e.Value)
+}
+
+// ConfigError list every invalid field of a Config, it is returned by the
+// Create constructors so all the problems are fixed at once.
+type ConfigError struct {
+	Fields []*FieldError
+}
+
+func (e *ConfigError) Error() string {
+	msg := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msg = append(msg, f.Error())
+	}
+	return fmt.Sprintf("invalid kafka config: %s", strings.Join(msg, "; "))
+}
+
+func (e *ConfigError) add(field string, value interface{}, message string) {
+	e.Fields = append(e.Fields, &FieldError{Field: field, Value: value, Message: message})
+}
+
+// addError add a *FieldError
+func (e *ConfigError) addError(err error) {
+	if f, ok := err.(*FieldError); ok {
+		e.Fields = append(e.Fields, f)
+	}
+}
+
+// err return e when it has fields, nil otherwise
+func (e *ConfigError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
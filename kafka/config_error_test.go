@@ -0,0 +1,44 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateProducerConfigError(t *testing.T) {
+	_, err := CreateProducer(&Config{
+		Version: "x.y",
+		Producer: ProducerConfig{
+			PartitionStrategy: "sticky",
+			Compression:       "brotli",
+		},
+		TLS: TLS{Enable: true, CertFile: "missing.pem", KeyFile: "missing.key", CaFile: "ca.pem"},
+	})
+
+	var cfgErr *ConfigError
+	assert.True(t, errors.As(err, &cfgErr))
+	fields := make([]string, 0, len(cfgErr.Fields))
+	for _, f := range cfgErr.Fields {
+		fields = append(fields, f.Field)
+	}
+	assert.Equal(t, []string{"version", "producer.partition_strategy", "tls.cert_file", "producer.compression"}, fields)
+}
+
+func TestCreateConsumerGroupConfigError(t *testing.T) {
+	_, err := CreateConsumerGroup(&Config{Consumer: ConsumerConfig{RebalanceStrategy: "fair"}})
+
+	var cfgErr *ConfigError
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.Len(t, cfgErr.Fields, 1)
+	assert.Equal(t, "consumer.rebalance_strategy", cfgErr.Fields[0].Field)
+}
+
+func TestCreateTransactionalProducerConfigError(t *testing.T) {
+	_, err := CreateTransactionalProducer(&Config{})
+
+	var cfgErr *ConfigError
+	assert.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, "producer.transactional_id", cfgErr.Fields[0].Field)
+}
@@ -2,11 +2,10 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/kiriminaja/kaj-golang-pkg/logger"
@@ -14,21 +13,60 @@ import (
 	"github.com/Shopify/sarama"
 )
 
+const (
+	consumeRetryBackoff = time.Second
+)
+
+var (
+	errNoProcessor     = errors.New("kafka consumer context requires a Handler, a Processor, a BatchHandler or a TxnHandler")
+	errNoRetryProducer = errors.New("kafka retry policy requires a Producer")
+	errNoTxnProducer   = errors.New("kafka consumer context TxnHandler requires a TxnProducer")
+)
+
 type consumerGroup struct {
 	config     *sarama.Config
 	brokers    []string
 	autoCommit bool
+	codecs     codecs
+	metrics    Metrics
 }
 
-// NewConsumer return consumer message broker
+// CreateConsumerGroup return consumer message broker, an invalid cfg return
+// a *ConfigError listing every invalid field.
+func CreateConsumerGroup(cfg *Config) (Consumer, error) {
+	config, err := newConsumerConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &consumerGroup{
+		config:     config,
+		brokers:    cfg.Brokers,
+		autoCommit: cfg.Consumer.AutoCommit,
+		codecs:     cfg.Codecs,
+		metrics:    metricsOf(cfg),
+	}, nil
+}
+
+// NewConsumerGroup return consumer message broker, see CreateConsumerGroup.
+//
+// Deprecated: NewConsumerGroup exit the process on error, use CreateConsumerGroup.
 func NewConsumerGroup(cfg *Config) Consumer {
-	m := &consumerGroup{}
+	m, err := CreateConsumerGroup(cfg)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	return m
+}
 
+// newConsumerConfig build the sarama configuration of a consumer group
+func newConsumerConfig(cfg *Config) (*sarama.Config, error) {
 	/**
 	 * Construct a new Sarama configuration.
 	 * The Kafka cluster version has to be defined before the consumer/producer is initialized.
 	 */
 	config := sarama.NewConfig()
+	problems := &ConfigError{}
 
 	if cfg.Version == "" {
 		cfg.Version = defaultVersion
@@ -36,28 +74,26 @@ func NewConsumerGroup(cfg *Config) Consumer {
 
 	version, err := sarama.ParseKafkaVersion(cfg.Version)
 	if err != nil {
-		logger.Fatal(fmt.Sprintf("parse kafka version got: %v", err))
+		problems.add("version", cfg.Version, "is not a kafka version")
 	}
 
-	if cfg.SASL.Enable {
-		config.Net.SASL.Enable = true
-		config.Net.SASL.User = cfg.SASL.User
-		config.Net.SASL.Password = cfg.SASL.Password
-		config.Net.SASL.Version = sarama.SASLHandshakeV0
-		config.Net.SASL.Handshake = true
-		config.Net.SASL.Mechanism = sarama.SASLMechanism(cfg.SASL.Mechanism)
-		config.Net.TLS.Enable = true
-	}
-
-	// The TLS configuration to use for secure connections if
-	// enabled (defaults to nil).
-	if config.Net.TLS.Enable || cfg.TLS.Enable {
-		config.Net.TLS.Config = createTlsConfig(cfg.TLS)
-	}
+	applySecurity(cfg, config, problems)
+	applyMetrics(cfg, config)
 
 	config.Version = version
 
-	config.Consumer.Offsets.Initial = cfg.Consumer.OffsetInitial
+	if cfg.Consumer.InitialOffset != "" {
+		initial, ok := offsetInitials[cfg.Consumer.InitialOffset]
+		if !ok {
+			problems.add("consumer.initial_offset", cfg.Consumer.InitialOffset, "must be one of oldest, newest")
+		}
+		config.Consumer.Offsets.Initial = initial
+	} else if cfg.Consumer.OffsetInitial != 0 {
+		if cfg.Consumer.OffsetInitial != sarama.OffsetNewest && cfg.Consumer.OffsetInitial != sarama.OffsetOldest {
+			problems.add("consumer.offset_initial", cfg.Consumer.OffsetInitial, "must be -1 (newest) or -2 (oldest)")
+		}
+		config.Consumer.Offsets.Initial = cfg.Consumer.OffsetInitial
+	}
 	config.Consumer.Return.Errors = true
 	config.Consumer.Group.Session.Timeout = time.Duration(cfg.Consumer.SessionTimeoutSecond) * time.Second
 	config.Consumer.Group.Heartbeat.Interval = time.Duration(cfg.Consumer.HeartbeatInterval) * time.Millisecond
@@ -69,12 +105,11 @@ func NewConsumerGroup(cfg *Config) Consumer {
 	st, ok := balanceStrategies[cfg.Consumer.RebalanceStrategy]
 
 	if !ok {
-		logger.Fatal(fmt.Sprintf(
-			`rebalance strateggy only available : "%s", "%s", "%s",   on setting value : "%s"`,
+		problems.add("consumer.rebalance_strategy", cfg.Consumer.RebalanceStrategy, fmt.Sprintf(
+			`must be one of "%s", "%s", "%s"`,
 			sarama.RoundRobinBalanceStrategyName,
 			sarama.RangeBalanceStrategyName,
 			sarama.StickyBalanceStrategyName,
-			cfg.Consumer.RebalanceStrategy,
 		))
 	}
 
@@ -86,64 +121,120 @@ func NewConsumerGroup(cfg *Config) Consumer {
 
 	config.Consumer.Group.Rebalance.Strategy = st
 	config.ClientID = cfg.ClientID
-	m.brokers = cfg.Brokers
-	m.config = config
-	m.autoCommit = cfg.Consumer.AutoCommit
 
-	return m
+	return config, problems.err()
 }
 
-// Subscribe message
-func (k *consumerGroup) Subscribe(ctx *ConsumerContext) {
-	fields := []logger.Field{
-		logger.SetField("topics", ctx.Topics),
-	}
+// prepare set the defaults of ctx and check it has a processor
+func (ctx *ConsumerContext) prepare() error {
 	if ctx.GroupID == "" {
 		ctx.GroupID = os.Getenv("KAFKA_CLIENT_ID")
 	}
+	if ctx.Context == nil {
+		ctx.Context = context.Background()
+	}
+	if ctx.Handler == nil && ctx.Processor == nil && ctx.BatchHandler == nil && ctx.TxnHandler == nil {
+		return errNoProcessor
+	}
+	if ctx.TxnHandler != nil && ctx.TxnProducer == nil {
+		return errNoTxnProducer
+	}
+	if ctx.Retry != nil && ctx.Retry.Producer == nil {
+		return errNoRetryProducer
+	}
+	return nil
+}
 
-	client, err := sarama.NewConsumerGroup(k.brokers, ctx.GroupID, k.config)
+// SubscribedTopics return Topics and the retry topics of Retry, the topics
+// the group consumes.
+func (ctx *ConsumerContext) SubscribedTopics() []string {
+	topics := append([]string{}, ctx.Topics...)
+	if ctx.Retry != nil {
+		for t := range ctx.Retry.retryTopics(ctx.GroupID, ctx.Topics) {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// NewGroupHandler return the sarama handler Subscribe consume ctx with, for
+// Consumer implementations running their own group session such as kafkatest.
+// offsets resolve ConsumerContext.StartAt, it sets the defaults of ctx.
+func NewGroupHandler(ctx *ConsumerContext, cfg *Config, offsets GroupOffsetLookup) (sarama.ConsumerGroupHandler, error) {
+	if err := ctx.prepare(); err != nil {
+		return nil, err
+	}
+	handler := newConsumerHandler(ctx, cfg.Consumer.AutoCommit)
+	handler.codecs = cfg.Codecs
+	handler.offsets = offsets
+	handler.metrics = metricsOf(cfg)
+	return handler, nil
+}
+
+// Subscribe consume ctx.Topics until ctx.Context is cancelled, then wait for
+// the in-flight messages, commit the marked offsets and close the group.
+// Signal handling is left to the caller, cancel ctx.Context to stop.
+func (k *consumerGroup) Subscribe(ctx *ConsumerContext) error {
+	fields := []logger.Field{
+		logger.SetField("topics", ctx.Topics),
+	}
+	if err := ctx.prepare(); err != nil {
+		return err
+	}
+	topics := ctx.SubscribedTopics()
 
+	saramaClient, err := sarama.NewClient(k.brokers, k.config)
 	if err != nil {
-		logger.Fatal(err.Error(), fields...)
+		return fmt.Errorf("create consumer group %s client got: %w", ctx.GroupID, err)
 	}
+	defer saramaClient.Close()
 
-	handler := NewConsumerHandler(ctx.Handler, k.autoCommit)
+	client, err := sarama.NewConsumerGroupFromClient(ctx.GroupID, saramaClient)
 
-	// kafka consumer client
-	nCtx, cancel := context.WithCancel(ctx.Context)
+	if err != nil {
+		return fmt.Errorf("create consumer group %s got: %w", ctx.GroupID, err)
+	}
 
-	defer func() {
-		_ = client.Close()
-	}()
-	// subscriber errors
+	handler := newConsumerHandler(ctx, k.autoCommit)
+	handler.codecs = k.codecs
+	handler.offsets = clientOffsets{saramaClient}
+	handler.metrics = k.metrics
+	handler.control.attach(client, ctx.MaxInFlight)
+	defer handler.control.attach(nil, ctx.MaxInFlight)
+
+	// subscriber errors, the channel is closed by client.Close
+	errDone := make(chan struct{})
 	go func() {
+		defer close(errDone)
 		for err := range client.Errors() {
 			logger.Error(fmt.Sprintf("[consumer] error %s", err.Error()), fields...)
 		}
 	}()
 
-	go func() {
-		for {
+	logger.Info(fmt.Sprintf("[consumer] sarama consumer up and running!... group %s, queue %v", ctx.GroupID, ctx.Topics), fields...)
+
+	for {
+		// Consume returns at the end of every session (rebalance), it is
+		// called again to rejoin the group until the context is done.
+		err := client.Consume(ctx.Context, topics, handler)
+		if ctx.Context.Err() != nil || errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			break
+		}
+		if err != nil {
+			logger.Error(logger.SetMessageFormat("[consumer] topic %v consume message error %s", ctx.Topics, err.Error()), fields...)
 			select {
-			case <-nCtx.Done():
-				logger.Warn(logger.SetMessageFormat("[consumer] stopped consume topics %v", ctx.Topics))
-				return
-			default:
-				err := client.Consume(nCtx, ctx.Topics, handler)
-				if err != nil {
-					logger.Error(logger.SetMessageFormat("[consumer] topic %v consume message error %s", ctx.Topics, err.Error()))
-				}
+			case <-ctx.Context.Done():
+			case <-time.After(consumeRetryBackoff):
 			}
 		}
-	}()
-	logger.Info(fmt.Sprintf("[consumer] sarama consumer up and running!... group %s, queue %v", ctx.Context, ctx.Topics), fields...)
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
-
-	<-sigterm // Await a sigterm signal before safely closing the consumer
+	}
 
-	cancel()
-	logger.Info("[consumer] Cancelled message without marking offsets", fields...)
+	logger.Warn(logger.SetMessageFormat("[consumer] stopped consume topics %v", ctx.Topics), fields...)
 
+	err = client.Close()
+	<-errDone
+	if err != nil {
+		return fmt.Errorf("close consumer group %s got: %w", ctx.GroupID, err)
+	}
+	return nil
 }
@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func subscribeConfig(addr string) *Config {
+	return &Config{
+		Brokers:  []string{addr},
+		Consumer: ConsumerConfig{SessionTimeoutSecond: 10, HeartbeatInterval: 3},
+	}
+}
+
+func TestSubscribeLifecycle(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 0)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "billing", broker),
+		"JoinGroupRequest": sarama.NewMockJoinGroupResponse(t).
+			SetMemberId("member-1").
+			SetGroupProtocol(sarama.RangeBalanceStrategyName),
+		"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&sarama.ConsumerGroupMemberAssignment{Topics: map[string][]int32{"orders": {0}}}),
+		"HeartbeatRequest": sarama.NewMockHeartbeatResponse(t),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("billing", "orders", 0, 0, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, sarama.OffsetOldest, 0).
+			SetOffset("orders", 0, sarama.OffsetNewest, 1),
+		"FetchRequest": sarama.NewMockSequence(
+			sarama.NewMockFetchResponse(t, 1).
+				SetMessage("orders", 0, 0, sarama.StringEncoder(`{"payload":{"id":"a"}}`)),
+			sarama.NewMockFetchResponse(t, 1),
+		),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"LeaveGroupRequest":   sarama.NewMockLeaveGroupResponse(t),
+	})
+
+	consumer, err := CreateConsumerGroup(subscribeConfig(broker.Addr()))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var consumed []int64
+	err = consumer.Subscribe(&ConsumerContext{
+		Context: ctx,
+		GroupID: "billing",
+		Topics:  []string{"orders"},
+		Processor: MessageHandlerFunc(func(m *MessageDecoder) error {
+			consumed = append(consumed, m.Offset)
+			m.Commit(m)
+			// stop once the message is done
+			cancel()
+			return nil
+		}),
+	})
+	// cancellation is a clean stop
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0}, consumed)
+	assert.True(t, errors.Is(ctx.Err(), context.Canceled))
+
+	// the marked offset is committed and the group left on the way out
+	committed := int64(-1)
+	left := false
+	for _, rr := range broker.History() {
+		switch req := rr.Request.(type) {
+		case *sarama.OffsetCommitRequest:
+			if offset, _, err := req.Offset("orders", 0); err == nil {
+				committed = offset
+			}
+		case *sarama.LeaveGroupRequest:
+			left = true
+		}
+	}
+	assert.Equal(t, int64(1), committed)
+	assert.True(t, left, "the group is closed")
+}
+
+func TestSubscribeError(t *testing.T) {
+	consumer, err := CreateConsumerGroup(subscribeConfig("127.0.0.1:1"))
+	assert.NoError(t, err)
+
+	err = consumer.Subscribe(&ConsumerContext{Context: context.Background(), GroupID: "billing", Topics: []string{"orders"}})
+	assert.Equal(t, errNoProcessor, err)
+
+	// an unreachable cluster is returned instead of exiting the process
+	err = consumer.Subscribe(&ConsumerContext{
+		Context:   context.Background(),
+		GroupID:   "billing",
+		Topics:    []string{"orders"},
+		Processor: MessageHandlerFunc(func(m *MessageDecoder) error { return nil }),
+	})
+	assert.ErrorContains(t, err, "create consumer group billing client got:")
+}
@@ -13,22 +13,31 @@ import (
 // Producer represents kafka publisher message topic
 type Producer interface {
 	Publish(ctx context.Context, msg *MessageContext) error
+	PublishBatch(ctx context.Context, msgs []*MessageContext) error
+	// Close flush the pending messages and close the producer
+	Close() error
 }
 
 // Consumer represents a Sarama consumer consumer interface
 type Consumer interface {
-	Subscribe(*ConsumerContext)
+	// Subscribe block until the context of ConsumerContext is cancelled
+	Subscribe(*ConsumerContext) error
 }
 
 type MessageContext struct {
-	Value     *BodyStateful
-	Key       []byte
+	Value *BodyStateful
+	Key   []byte
+	// Headers record headers, the request id and the trace context of the
+	// publish context are added to them.
+	Headers   map[string]string
 	LogId     interface{}
 	Topic     string
 	Partition int32
 	Offset    int64
 	TimeStamp time.Time
 	Verbose   bool
+	// Codec serialize Value instead of the codec of the topic when set
+	Codec Codec
 }
 
 type BodyStateful struct {
@@ -41,13 +50,80 @@ type BodyStateful struct {
 type SourceData struct {
 	Service       string `json:"service"`
 	ConsumerGroup string `json:"consumer_group,omitempty"`
+	// Topic the message was first consumed from, set on retried messages
+	Topic string `json:"topic,omitempty"`
+	// Attempt number of failed processing of a retried message
+	Attempt int `json:"attempt,omitempty"`
 }
 
 type ConsumerContext struct {
 	Handler MessageProcessorFunc
-	Topics  []string
-	GroupID string
-	Context context.Context
+	// Processor is used instead of Handler when set, its errors are
+	// routed by Retry.
+	Processor MessageProcessor
+	// Retry policy of the messages failed by Processor, when nil the error
+	// is logged and the message is done.
+	Retry *RetryPolicy
+	// Concurrency number of workers processing a claim in parallel, messages
+	// with the same key keep their order. 0 or 1 process one at a time.
+	Concurrency int
+	// BatchHandler is used instead of Handler and Processor when set, it
+	// receives up to BatchSize messages of a claim collected within BatchWindow.
+	BatchHandler BatchProcessorFunc
+	// BatchSize defaults to 100
+	BatchSize int
+	// BatchWindow maximum wait for a batch to fill up, defaults to 1s
+	BatchWindow time.Duration
+	// TxnHandler is used instead of the other handlers when set, each
+	// message is processed in a transaction of TxnProducer committing the
+	// published messages with the consumed offset. Consume with
+	// ConsumerConfig.IsolationLevel 1 (read committed) downstream. The
+	// claims share TxnProducer and run their transactions one at a time.
+	TxnHandler  TxnProcessorFunc
+	TxnProducer TransactionalProducer
+	// StartAt start the group at the first message at or after this time on
+	// the partitions it has no committed offset for yet, e.g. a new group
+	// replaying a day. Use Admin.ResetOffsets to move an existing group.
+	StartAt time.Time
+	// StartOffsets start the group at explicit offsets per topic and
+	// partition like StartAt, it takes precedence over StartAt.
+	StartOffsets map[string]map[int32]int64
+	// OnSessionStart and OnSessionEnd are called when the member joins and
+	// leaves a group session, every rebalance ends the session.
+	OnSessionStart SessionFunc
+	OnSessionEnd   SessionFunc
+	// OnPartitionsAssigned is called with the claims of a new session before
+	// they are consumed, OnPartitionsRevoked once their messages are done
+	// and before the offsets are committed, e.g. to flush per partition
+	// buffers. A rebalance revoke every partition of the member.
+	OnPartitionsAssigned SessionFunc
+	OnPartitionsRevoked  SessionFunc
+	// PoisonHandler receive the messages the codec of their topic cannot
+	// decode or Processor failed with ErrPoison, with their raw value.
+	// Without it they are published to the dead letter topic of Retry, or
+	// logged and skipped without Retry.
+	PoisonHandler PoisonHandlerFunc
+	// CommitOnSuccess mark the offset of a message once the handler returns
+	// nil or Retry routed it, instead of before the handler with
+	// ConsumerConfig.AutoCommit. A failed message without Retry is not
+	// marked itself, it is consumed again after a restart only while no
+	// later message of its partition succeeded. The next successful message
+	// mark past it and it is then skipped for good, use Retry to keep it.
+	CommitOnSuccess bool
+	// Codec decode the messages of every topic instead of Config.Codecs
+	// when set, e.g. RawCodec to receive the record values as is.
+	Codec Codec
+	// Control pause and resume partitions of the running Subscribe, see
+	// NewConsumerControl.
+	Control *ConsumerControl
+	// MaxInFlight pause every partition once this many messages are taken
+	// from the claims and not done yet, e.g. queued for the Concurrency
+	// workers or collected in a batch. They are resumed at half of it, 0
+	// disables the backpressure.
+	MaxInFlight int
+	Topics      []string
+	GroupID     string
+	Context     context.Context
 }
 
 var balanceStrategies = map[string]sarama.BalanceStrategy{
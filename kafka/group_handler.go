@@ -1,61 +1,419 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
 
 	"github.com/Shopify/sarama"
 )
 
+const (
+	republishBackoff = time.Second
+	// workerQueueSize messages waiting per worker of a concurrent claim
+	workerQueueSize = 64
+)
+
 // Consumer represents a Sarama consumer group consumer
 type consumerHandler struct {
-	msgProcessor MessageProcessorFunc
-	autoCommit   bool
+	processor      MessageProcessor
+	batchProcessor BatchProcessorFunc
+	txnProcessor   TxnProcessorFunc
+	txnProducer    TransactionalProducer
+	batchSize      int
+	batchWindow    time.Duration
+	autoCommit     bool
+	groupID        string
+	concurrency    int
+	retry          *RetryPolicy
+	retryTopics    map[string]retryTopic
+	codecs         codecs
+	startAt        time.Time
+	startOffsets   map[string]map[int32]int64
+	offsets        GroupOffsetLookup
+	// started partitions already moved to startAt or startOffsets
+	started map[string]bool
+	hooks   rebalanceHooks
+	metrics Metrics
+	control *ConsumerControl
+	// poisonHandler receive the messages that cannot be decoded
+	poisonHandler   PoisonHandlerFunc
+	commitOnSuccess bool
+	// codec decode every topic instead of codecs when set
+	codec Codec
+	// txnMu serialize the transactions of the claims on txnProducer, a
+	// transactional producer runs one transaction at a time
+	txnMu sync.Mutex
 }
 
 // NewConsumerHandler return consumer handler
 func NewConsumerHandler(msgProcessor MessageProcessorFunc, autoCommit bool) sarama.ConsumerGroupHandler {
-	return &consumerHandler{msgProcessor: msgProcessor, autoCommit: autoCommit}
+	return newConsumerHandler(&ConsumerContext{Handler: msgProcessor}, autoCommit)
+}
+
+func newConsumerHandler(ctx *ConsumerContext, autoCommit bool) *consumerHandler {
+	c := &consumerHandler{
+		processor:      ctx.Processor,
+		batchProcessor: ctx.BatchHandler,
+		txnProcessor:   ctx.TxnHandler,
+		txnProducer:    ctx.TxnProducer,
+		batchSize:      ctx.BatchSize,
+		batchWindow:    ctx.BatchWindow,
+		autoCommit:     autoCommit,
+		groupID:        ctx.GroupID,
+		concurrency:    ctx.Concurrency,
+		retry:          ctx.Retry,
+		startAt:        ctx.StartAt,
+		startOffsets:   ctx.StartOffsets,
+		started:        map[string]bool{},
+		hooks: rebalanceHooks{
+			sessionStart: ctx.OnSessionStart,
+			assigned:     ctx.OnPartitionsAssigned,
+			revoked:      ctx.OnPartitionsRevoked,
+			sessionEnd:   ctx.OnSessionEnd,
+		},
+		metrics:         noopMetrics{},
+		control:         ctx.Control,
+		poisonHandler:   ctx.PoisonHandler,
+		commitOnSuccess: ctx.CommitOnSuccess,
+		codec:           ctx.Codec,
+	}
+	if c.control == nil {
+		c.control = NewConsumerControl()
+	}
+	c.control.attach(nil, ctx.MaxInFlight)
+	if c.batchSize < 1 {
+		c.batchSize = defaultBatchSize
+	}
+	if c.batchWindow <= 0 {
+		c.batchWindow = defaultBatchWindow
+	}
+	if c.processor == nil && ctx.Handler != nil {
+		handler := ctx.Handler
+		c.processor = MessageHandlerFunc(func(m *MessageDecoder) error {
+			handler(m)
+			return nil
+		})
+	}
+	if c.retry != nil {
+		c.retryTopics = c.retry.retryTopics(ctx.GroupID, ctx.Topics)
+	}
+	return c
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (c *consumerHandler) Setup(sarama.ConsumerGroupSession) error {
-	return nil
+func (c *consumerHandler) Setup(session sarama.ConsumerGroupSession) error {
+	return c.setup(session)
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (c *consumerHandler) Cleanup(sarama.ConsumerGroupSession) error {
+func (c *consumerHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	c.cleanup(session)
 	return nil
 }
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
 func (c *consumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	c.control.claim(claim.Topic(), claim.Partition())
+	defer c.control.release(claim.Topic(), claim.Partition())
+
+	if c.txnProcessor != nil {
+		return c.consumeTxn(session, claim)
+	}
+	if c.batchProcessor != nil {
+		return c.consumeBatch(session, claim)
+	}
+	if c.concurrency > 1 {
+		return c.consumeConcurrent(session, claim)
+	}
 
 	// NOTE:
 	// Do not move the code below to a goroutine.
 	// The `ConsumeClaim` itself is called within a goroutine, see:
 	// https://github.com/Shopify/sarama/blob/master/consumer_group.go#L27-L29
-	for msg := range claim.Messages() {
-		bodyFull := &BodyStateful{}
-		json.Unmarshal(msg.Value, bodyFull)
-		if c.autoCommit {
-			session.MarkMessage(msg, "")
-		}
-		bodyFormat, _ := json.Marshal(bodyFull.Body)
-		c.msgProcessor(&MessageDecoder{
-			Body:      bodyFormat,
-			Key:       msg.Key,
-			Error:     bodyFull.Error,
-			Source:    bodyFull.Source,
-			Partition: msg.Partition,
-			Message:   bodyFull.Message,
-			TimeStamp: msg.Timestamp,
-			Offset:    msg.Offset,
-			Topic:     msg.Topic,
-			Commit: func(m *MessageDecoder) {
-				session.MarkOffset(m.Topic, m.Partition, m.Offset+1, "")
-			},
-		})
+	for {
+		msg, ok := c.next(session, claim)
+		if !ok {
+			return nil
+		}
+		handled := c.handle(session, msg, func() { session.MarkMessage(msg, "") })
+		c.control.done(1)
+		if !handled {
+			return nil
+		}
 	}
+}
 
-	return nil
+// consumeConcurrent process the claim with a worker per key hash, messages of
+// the same key keep their order and offsets are marked up to the lowest
+// message still in progress.
+func (c *consumerHandler) consumeConcurrent(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tracker := newOffsetTracker(func(offset int64) {
+		session.MarkOffset(claim.Topic(), claim.Partition(), offset+1, "")
+	})
+
+	var wg sync.WaitGroup
+	queues := make([]chan *sarama.ConsumerMessage, c.concurrency)
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, workerQueueSize)
+		wg.Add(1)
+		go func(queue chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for msg := range queue {
+				// leave the queued messages once the session is over,
+				// they are not marked and will be consumed again
+				if session.Context().Err() != nil {
+					c.control.done(1)
+					continue
+				}
+				offset := msg.Offset
+				// a handled message is done even when it is not acked, e.g.
+				// failed with CommitOnSuccess, so it does not hold back
+				// the offsets after it
+				if c.handle(session, msg, func() { tracker.ack(offset) }) {
+					tracker.release(offset)
+				}
+				c.control.done(1)
+			}
+		}(queues[i])
+	}
+
+	defer func() {
+		for _, q := range queues {
+			close(q)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		msg, ok := c.next(session, claim)
+		if !ok {
+			return nil
+		}
+		tracker.add(msg.Offset)
+		select {
+		case queues[c.worker(msg)] <- msg:
+		case <-session.Context().Done():
+			c.control.done(1)
+			return nil
+		}
+	}
+}
+
+// worker return the worker index of msg, keyed messages always go to the same worker
+func (c *consumerHandler) worker(msg *sarama.ConsumerMessage) int {
+	if len(msg.Key) == 0 {
+		return int(msg.Offset % int64(c.concurrency))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(msg.Key)
+	return int(h.Sum32() % uint32(c.concurrency))
+}
+
+// next wait for the next message of the claim while it is not paused, false
+// when the claim or the session is over. The message is in flight until
+// control.done.
+func (c *consumerHandler) next(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) (*sarama.ConsumerMessage, bool) {
+	if !c.control.wait(session.Context(), claim.Topic(), claim.Partition()) {
+		return nil, false
+	}
+	select {
+	case msg, ok := <-claim.Messages():
+		if ok {
+			c.control.received(1)
+			c.metrics.Consumed(c.groupID, msg.Topic, msg.Partition, lag(claim, msg))
+		}
+		return msg, ok
+	case <-session.Context().Done():
+		// stop without taking the buffered messages, the one in
+		// progress is already finished at this point
+		return nil, false
+	}
+}
+
+// handle process a single message and call ack once it is done, false means
+// the session ended before the message was done.
+func (c *consumerHandler) handle(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, ack func()) bool {
+	if !c.due(session, msg) {
+		return false
+	}
+
+	decoder, bodyFull, err := c.decode(session.Context(), msg, ack)
+	if err != nil {
+		if !c.poison(session.Context(), msg, err) {
+			return false
+		}
+		ack()
+		return true
+	}
+	defer decoder.finish()
+	if c.autoCommit && !c.commitOnSuccess {
+		ack()
+	}
+
+	started := time.Now()
+	err = c.processor.Processor(decoder)
+	if errors.Is(err, errSessionDone) {
+		return false
+	}
+	c.metrics.Handled(c.groupID, msg.Topic, time.Since(started), err)
+	if err == nil {
+		if c.commitOnSuccess {
+			ack()
+		}
+		return true
+	}
+
+	if errors.Is(err, ErrPoison) {
+		if !c.poison(session.Context(), msg, err) {
+			return false
+		}
+		ack()
+		return true
+	}
+	if c.retry == nil {
+		logger.Error(logger.SetMessageFormat("[consumer] topic %s partition %d offset %d processing error %s",
+			msg.Topic, msg.Partition, msg.Offset, err.Error()))
+		return true
+	}
+
+	if !c.republish(session.Context(), c.origin(msg.Topic), decoder, bodyFull, err) {
+		return false
+	}
+	ack()
+	return true
+}
+
+// decode build the decoder of msg with the codec of its topic, ack is called
+// by its Commit
+func (c *consumerHandler) decode(ctx context.Context, msg *sarama.ConsumerMessage, ack func()) (*MessageDecoder, *BodyStateful, error) {
+	codec := c.codecOf(c.origin(msg.Topic))
+	payload, bodyFull, err := codec.Decode(msg.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := fromRecordHeaders(msg.Headers)
+	sourceFromHeaders(bodyFull, headers)
+	msgCtx, span := consumeContext(ctx, msg.Topic, headers)
+	return &MessageDecoder{
+		Body:      payload,
+		Key:       msg.Key,
+		Headers:   headers,
+		ctx:       msgCtx,
+		span:      span,
+		codec:     codec,
+		Error:     bodyFull.Error,
+		Source:    bodyFull.Source,
+		Partition: msg.Partition,
+		Message:   bodyFull.Message,
+		TimeStamp: msg.Timestamp,
+		Offset:    msg.Offset,
+		Topic:     msg.Topic,
+		Commit: func(*MessageDecoder) {
+			ack()
+		},
+	}, bodyFull, nil
+}
+
+// decodeError log a message that cannot be decoded
+func decodeError(msg *sarama.ConsumerMessage, err error) {
+	logger.Error(logger.SetMessageFormat("[consumer] topic %s partition %d offset %d skipped, decode error %s",
+		msg.Topic, msg.Partition, msg.Offset, err.Error()))
+}
+
+// codecOf return the codec of the messages of topic
+func (c *consumerHandler) codecOf(topic string) Codec {
+	if c.codec != nil {
+		return c.codec
+	}
+	return c.codecs.get(topic)
+}
+
+// origin return the topic a message of topic was first consumed from
+func (c *consumerHandler) origin(topic string) string {
+	if rt, ok := c.retryTopics[topic]; ok {
+		return rt.origin
+	}
+	return topic
+}
+
+// due wait until a message of a retry topic is due, false when the session ends first
+func (c *consumerHandler) due(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) bool {
+	rt, ok := c.retryTopics[msg.Topic]
+	if !ok {
+		return true
+	}
+	return wait(session.Context(), time.Until(msg.Timestamp.Add(rt.delay)))
+}
+
+// republish send the failed message to its next retry topic or to the dead
+// letter topic, it keeps trying until the session ends.
+func (c *consumerHandler) republish(ctx context.Context, origin string, decoder *MessageDecoder, body *BodyStateful, cause error) bool {
+	msg := c.retryMessage(origin, decoder, body, cause)
+	topic := msg.Topic
+	source := msg.Value.Source
+
+	for {
+		err := c.retry.Producer.Publish(decoder.Context(), msg)
+		if err == nil {
+			logger.Warn(logger.SetMessageFormat("[consumer] topic %s offset %d attempt %d failed with %s, sent to %s",
+				decoder.Topic, decoder.Offset, source.Attempt, cause.Error(), topic))
+			return true
+		}
+		logger.Error(logger.SetMessageFormat("[consumer] republish to %s got: %s", topic, err.Error()))
+		if !wait(ctx, republishBackoff) {
+			return false
+		}
+	}
+}
+
+// retryMessage build the message sending a failed message to its next retry
+// topic or to the dead letter topic, with the original headers and codec.
+func (c *consumerHandler) retryMessage(origin string, decoder *MessageDecoder, body *BodyStateful, cause error) *MessageContext {
+	source := &SourceData{
+		Service:       os.Getenv("APP_NAME"),
+		ConsumerGroup: c.groupID,
+		Topic:         origin,
+	}
+	if body.Source != nil {
+		source.Service = body.Source.Service
+		source.Attempt = body.Source.Attempt
+	}
+	topic := c.retry.next(origin, c.groupID, source.Attempt)
+	source.Attempt++
+
+	return &MessageContext{
+		Topic:   topic,
+		Key:     decoder.Key,
+		Headers: retryHeaders(decoder.Headers, source, cause.Error()),
+		Codec:   c.codecOf(origin),
+		Value: &BodyStateful{
+			Body:    json.RawMessage(decoder.Body),
+			Message: body.Message,
+			Error:   cause.Error(),
+			Source:  source,
+		},
+		LogId: decoder.Offset,
+	}
+}
+
+// wait sleep for d, false when ctx is done first
+func wait(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
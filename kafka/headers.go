@@ -0,0 +1,96 @@
+package kafka
+
+import (
+	"context"
+	"sort"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+	"github.com/getsentry/sentry-go"
+)
+
+const (
+	// HeaderRequestID carry the logger request id of the publisher
+	HeaderRequestID = "X-Request-Id"
+	// HeaderSentryTrace carry the sentry trace of the publisher
+	HeaderSentryTrace = sentry.SentryTraceHeader
+	// HeaderBaggage carry the sentry dynamic sampling context
+	HeaderBaggage = sentry.SentryBaggageHeader
+	// HeaderRetryTopic carry the topic a retried message was first consumed from
+	HeaderRetryTopic = "X-Retry-Topic"
+	// HeaderRetryAttempt carry the number of failed processing of a retried message
+	HeaderRetryAttempt = "X-Retry-Attempt"
+	// HeaderRetryError carry the last processing error of a retried message
+	HeaderRetryError = "X-Retry-Error"
+	// HeaderDecodeError carry the decode error of a poison message sent to
+	// the dead letter topic with its raw value
+	HeaderDecodeError = "X-Decode-Error"
+	// HeaderDeliverAt carry the delivery time of a scheduled message, RFC 3339
+	HeaderDeliverAt = "X-Deliver-At"
+	// HeaderDeliverTopic carry the topic a scheduled message is delivered to
+	HeaderDeliverTopic = "X-Deliver-Topic"
+)
+
+// injectHeaders copy headers adding the request id and the trace context of
+// ctx, headers set by the caller are kept.
+func injectHeaders(ctx context.Context, headers map[string]string) map[string]string {
+	result := make(map[string]string, len(headers)+3)
+	if ctx != nil {
+		if id := logger.RequestIDFromContext(ctx); id != "" {
+			result[HeaderRequestID] = id
+		}
+		if span := sentry.SpanFromContext(ctx); span != nil {
+			result[HeaderSentryTrace] = span.ToSentryTrace()
+			if baggage := span.ToBaggage(); baggage != "" {
+				result[HeaderBaggage] = baggage
+			}
+		}
+	}
+	for k, v := range headers {
+		result[k] = v
+	}
+	return result
+}
+
+func toRecordHeaders(headers map[string]string) []sarama.RecordHeader {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]sarama.RecordHeader, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, sarama.RecordHeader{Key: []byte(k), Value: []byte(headers[k])})
+	}
+	return result
+}
+
+func fromRecordHeaders(headers []*sarama.RecordHeader) map[string]string {
+	result := make(map[string]string, len(headers))
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		result[string(h.Key)] = string(h.Value)
+	}
+	return result
+}
+
+// consumeContext return parent carrying the request id of headers, and the
+// transaction continuing the publisher trace when the headers have one.
+func consumeContext(parent context.Context, topic string, headers map[string]string) (context.Context, *sentry.Span) {
+	ctx := parent
+	if id := headers[HeaderRequestID]; id != "" {
+		ctx = logger.ContextWithRequestID(ctx, id)
+	}
+	trace := headers[HeaderSentryTrace]
+	if trace == "" {
+		return ctx, nil
+	}
+	span := sentry.StartTransaction(ctx, "kafka.consume "+topic,
+		sentry.WithOpName("queue.process"),
+		sentry.ContinueFromHeaders(trace, headers[HeaderBaggage]),
+	)
+	return span.Context(), span
+}
@@ -0,0 +1,35 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeadersPropagation(t *testing.T) {
+	ctx := logger.ContextWithRequestID(context.Background(), "req-1")
+	headers := injectHeaders(ctx, map[string]string{"X-Correlation-Id": "corr-1"})
+	assert.Equal(t, map[string]string{
+		HeaderRequestID:    "req-1",
+		"X-Correlation-Id": "corr-1",
+	}, headers)
+
+	// caller headers win over the context
+	headers = injectHeaders(ctx, map[string]string{HeaderRequestID: "req-2"})
+	assert.Equal(t, "req-2", headers[HeaderRequestID])
+
+	records := toRecordHeaders(map[string]string{"b": "2", "a": "1"})
+	assert.Equal(t, []sarama.RecordHeader{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+	}, records)
+
+	consumed := fromRecordHeaders([]*sarama.RecordHeader{&records[0], &records[1], {Key: []byte(HeaderRequestID), Value: []byte("req-3")}})
+	msgCtx, span := consumeContext(context.Background(), "orders", consumed)
+	assert.Nil(t, span)
+	assert.Equal(t, "req-3", logger.RequestIDFromContext(msgCtx))
+}
@@ -0,0 +1,308 @@
+// Package kafkatest provide an in memory kafka broker implementing
+// kafka.Producer and kafka.Consumer, to test publishers and handlers without
+// a cluster.
+//
+//	broker := kafkatest.NewBroker(&kafka.Config{})
+//	go broker.Consumer().Subscribe(&kafka.ConsumerContext{Context: ctx, GroupID: "svc", Topics: []string{"orders"}, Processor: handler})
+//	_ = broker.Producer().Publish(ctx, &kafka.MessageContext{Topic: "orders", Value: &kafka.BodyStateful{Body: order}})
+//	err := broker.WaitConsumed(ctx, "svc", "orders")
+package kafkatest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+
+	"github.com/Shopify/sarama"
+)
+
+// Broker in memory cluster, topics are created on first use with a single
+// partition unless created before with CreateTopic. Messages are kept for the
+// lifetime of the broker.
+type Broker struct {
+	cfg *kafka.Config
+	mu  sync.Mutex
+	// topics messages per partition, the offset is the index
+	topics map[string][][]*sarama.ConsumerMessage
+	groups map[string]*group
+	// changed is closed and replaced on every publish, commit and rebalance
+	changed chan struct{}
+	now     func() time.Time
+}
+
+// Message published message, see Broker.Messages
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+	codec     kafka.Codec
+}
+
+// NewBroker return an empty broker, cfg.Codecs and cfg.Consumer are used as by
+// kafka.CreateProducer and kafka.CreateConsumerGroup. A group without
+// committed offset start from the oldest message unless
+// cfg.Consumer.InitialOffset is "newest", so messages published before
+// Subscribe joins are consumed.
+func NewBroker(cfg *kafka.Config) *Broker {
+	if cfg == nil {
+		cfg = &kafka.Config{}
+	}
+	return &Broker{
+		cfg:     cfg,
+		topics:  map[string][][]*sarama.ConsumerMessage{},
+		groups:  map[string]*group{},
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+// Producer return a producer publishing to the broker
+func (b *Broker) Producer() kafka.Producer {
+	return &producer{broker: b}
+}
+
+// Consumer return a consumer of the broker, each Subscribe join its group as
+// a new member.
+func (b *Broker) Consumer() kafka.Consumer {
+	return &consumer{broker: b}
+}
+
+// CreateTopic create topic with partitions, messages are spread by key hash
+// like the default kafka.ProducerConfig partition strategy.
+func (b *Broker) CreateTopic(topic string, partitions int32) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[topic]; ok {
+		return fmt.Errorf("create topic %s got: %w", topic, sarama.ErrTopicAlreadyExists)
+	}
+	if partitions < 1 {
+		partitions = 1
+	}
+	b.topics[topic] = make([][]*sarama.ConsumerMessage, partitions)
+	return nil
+}
+
+// Messages return the messages published to topic ordered by partition and
+// offset
+func (b *Broker) Messages(topic string) []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make([]*Message, 0)
+	for _, partition := range b.topics[topic] {
+		for _, msg := range partition {
+			result = append(result, b.message(msg))
+		}
+	}
+	return result
+}
+
+// Committed return the offset committed by group on a partition, -1 when it
+// has none.
+func (b *Broker) Committed(group, topic string, partition int32) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if g, ok := b.groups[group]; ok {
+		if offset, ok := g.committed[topic][partition]; ok {
+			return offset
+		}
+	}
+	return -1
+}
+
+// CommittedOffset implement kafka.GroupOffsetLookup
+func (b *Broker) CommittedOffset(group, topic string, partition int32) (int64, error) {
+	return b.Committed(group, topic, partition), nil
+}
+
+// WaitConsumed wait until group has committed every message published to
+// topics, i.e. until its handlers are done with them, or ctx is done.
+func (b *Broker) WaitConsumed(ctx context.Context, group string, topics ...string) error {
+	return b.wait(ctx, func() bool {
+		g, ok := b.groups[group]
+		for _, topic := range topics {
+			for partition, msgs := range b.topics[topic] {
+				if len(msgs) == 0 {
+					continue
+				}
+				if !ok || g.committed[topic][int32(partition)] < int64(len(msgs)) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+// GetOffset implement kafka.OffsetLookup, at is a time in milliseconds or
+// sarama.OffsetOldest and sarama.OffsetNewest.
+func (b *Broker) GetOffset(topic string, partition int32, at int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msgs, err := b.partition(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	switch at {
+	case sarama.OffsetOldest:
+		return 0, nil
+	case sarama.OffsetNewest:
+		return int64(len(msgs)), nil
+	}
+	for _, msg := range msgs {
+		if msg.Timestamp.UnixNano()/int64(time.Millisecond) >= at {
+			return msg.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+// topic return the partitions of topic, creating it. b.mu must be held.
+func (b *Broker) topic(topic string) [][]*sarama.ConsumerMessage {
+	partitions, ok := b.topics[topic]
+	if !ok {
+		partitions = make([][]*sarama.ConsumerMessage, 1)
+		b.topics[topic] = partitions
+	}
+	return partitions
+}
+
+// partition return the messages of a partition. b.mu must be held.
+func (b *Broker) partition(topic string, partition int32) ([]*sarama.ConsumerMessage, error) {
+	partitions := b.topic(topic)
+	if partition < 0 || int(partition) >= len(partitions) {
+		return nil, fmt.Errorf("topic %s partition %d got: %w", topic, partition, sarama.ErrUnknownTopicOrPartition)
+	}
+	return partitions[partition], nil
+}
+
+// append store msg in the partition of its key and set its partition and offset
+func (b *Broker) append(msg *sarama.ProducerMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	partitions := b.topic(msg.Topic)
+	partition, err := sarama.NewHashPartitioner(msg.Topic).Partition(msg, int32(len(partitions)))
+	if err != nil {
+		return err
+	}
+	record := &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: partition,
+		Offset:    int64(len(partitions[partition])),
+		Timestamp: msg.Timestamp,
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = b.now()
+	}
+	if msg.Key != nil {
+		if record.Key, err = msg.Key.Encode(); err != nil {
+			return err
+		}
+	}
+	if record.Value, err = msg.Value.Encode(); err != nil {
+		return err
+	}
+	for i := range msg.Headers {
+		header := msg.Headers[i]
+		record.Headers = append(record.Headers, &header)
+	}
+
+	msg.Partition = partition
+	msg.Offset = record.Offset
+	partitions[partition] = append(partitions[partition], record)
+	b.notify()
+	return nil
+}
+
+// message return the Message of a record. b.mu must be held.
+func (b *Broker) message(msg *sarama.ConsumerMessage) *Message {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	// retried messages keep the codec of the topic they come from
+	origin := msg.Topic
+	if topic, ok := headers[kafka.HeaderRetryTopic]; ok {
+		origin = topic
+	}
+	codec, ok := b.cfg.Codecs[origin]
+	if !ok || codec == nil {
+		codec = kafka.EnvelopeCodec
+	}
+	return &Message{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+		codec:     codec,
+	}
+}
+
+// notify wake the waiters of a change. b.mu must be held.
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// wait block until done return true, it is called with b.mu held, or ctx is done
+func (b *Broker) wait(ctx context.Context, done func() bool) error {
+	for {
+		b.mu.Lock()
+		if done() {
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Decode return the payload and the envelope of the message with the codec
+// of its topic
+func (m *Message) Decode() ([]byte, *kafka.BodyStateful, error) {
+	return m.codec.Decode(m.Value)
+}
+
+// Unmarshal decode the payload of the message into out
+func (m *Message) Unmarshal(out interface{}) error {
+	payload, _, err := m.Decode()
+	if err != nil {
+		return err
+	}
+	return m.codec.Unmarshal(payload, out)
+}
+
+// sortedPartitions return the partitions of topics sorted by topic and
+// partition. b.mu must be held.
+func (b *Broker) sortedPartitions(topics []string) []topicPartition {
+	result := make([]topicPartition, 0)
+	for _, topic := range topics {
+		for partition := range b.topic(topic) {
+			result = append(result, topicPartition{topic: topic, partition: int32(partition)})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].topic != result[j].topic {
+			return result[i].topic < result[j].topic
+		}
+		return result[i].partition < result[j].partition
+	})
+	return result
+}
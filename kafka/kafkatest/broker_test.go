@@ -0,0 +1,406 @@
+package kafkatest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type order struct {
+	ID string `json:"id"`
+}
+
+func publish(t *testing.T, p kafka.Producer, topic string, ids ...string) {
+	for _, id := range ids {
+		err := p.Publish(context.Background(), &kafka.MessageContext{
+			Topic: topic,
+			Key:   []byte(id),
+			Value: &kafka.BodyStateful{Body: order{ID: id}, Message: "created"},
+		})
+		assert.NoError(t, err)
+	}
+}
+
+func TestBrokerPublishAndConsume(t *testing.T) {
+	broker := NewBroker(nil)
+	assert.NoError(t, broker.CreateTopic("orders", 3))
+	publish(t, broker.Producer(), "orders", "a", "b", "c", "a")
+
+	messages := broker.Messages("orders")
+	assert.Len(t, messages, 4)
+	var first order
+	assert.NoError(t, messages[0].Unmarshal(&first))
+	assert.NotEmpty(t, first.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	consumed := map[string]int{}
+	done := make(chan error, 1)
+	go func() {
+		done <- broker.Consumer().Subscribe(&kafka.ConsumerContext{
+			Context: ctx,
+			GroupID: "billing",
+			Topics:  []string{"orders"},
+			Processor: kafka.MessageHandlerFunc(func(m *kafka.MessageDecoder) error {
+				var o order
+				if err := m.Cast(&o); err != nil {
+					return err
+				}
+				mu.Lock()
+				consumed[o.ID]++
+				mu.Unlock()
+				m.Commit(m)
+				return nil
+			}),
+		})
+	}()
+
+	assert.NoError(t, broker.WaitConsumed(ctx, "billing", "orders"))
+	mu.Lock()
+	assert.Equal(t, map[string]int{"a": 2, "b": 1, "c": 1}, consumed)
+	mu.Unlock()
+
+	// keyed messages share a partition, committed past its last message
+	var partitionA []int32
+	perPartition := map[int32]int64{}
+	for _, m := range broker.Messages("orders") {
+		perPartition[m.Partition]++
+		if string(m.Key) == "a" {
+			partitionA = append(partitionA, m.Partition)
+		}
+	}
+	assert.Equal(t, partitionA[0], partitionA[1])
+	assert.Equal(t, perPartition[partitionA[0]], broker.Committed("billing", "orders", partitionA[0]))
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestBrokerRetryToDeadLetter(t *testing.T) {
+	broker := NewBroker(nil)
+	producer := broker.Producer()
+	publish(t, producer, "orders", "a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		_ = broker.Consumer().Subscribe(&kafka.ConsumerContext{
+			Context: ctx,
+			GroupID: "billing",
+			Topics:  []string{"orders"},
+			Retry:   &kafka.RetryPolicy{Producer: producer},
+			Processor: kafka.MessageHandlerFunc(func(m *kafka.MessageDecoder) error {
+				return errors.New("out of stock")
+			}),
+		})
+	}()
+
+	assert.NoError(t, broker.WaitConsumed(ctx, "billing", "orders"))
+	dead := broker.Messages("orders.billing.dlq")
+	if assert.Len(t, dead, 1) {
+		_, body, err := dead[0].Decode()
+		assert.NoError(t, err)
+		assert.Equal(t, "out of stock", body.Error)
+		assert.Equal(t, "orders", body.Source.Topic)
+	}
+}
+
+func TestBrokerRetryPerGroup(t *testing.T) {
+	broker := NewBroker(nil)
+	producer := broker.Producer()
+	publish(t, producer, "orders", "a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	attempts := map[string][]int{}
+	subscribe := func(group string, fail bool) {
+		_ = broker.Consumer().Subscribe(&kafka.ConsumerContext{
+			Context: ctx,
+			GroupID: group,
+			Topics:  []string{"orders"},
+			Retry:   &kafka.RetryPolicy{Producer: producer, Delays: []time.Duration{10 * time.Millisecond}},
+			Processor: kafka.MessageHandlerFunc(func(m *kafka.MessageDecoder) error {
+				mu.Lock()
+				defer mu.Unlock()
+				attempt := 0
+				if m.Source != nil {
+					attempt = m.Source.Attempt
+				}
+				attempts[group] = append(attempts[group], attempt)
+				if fail && attempt == 0 {
+					return errors.New("out of stock")
+				}
+				m.Commit(m)
+				return nil
+			}),
+		})
+	}
+	go subscribe("billing", true)
+	go subscribe("shipping", false)
+
+	// billing retry its own failure, shipping does not see it
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts["billing"]) == 2 && len(attempts["shipping"]) == 1
+	}, 4*time.Second, 10*time.Millisecond)
+	assert.NoError(t, broker.WaitConsumed(ctx, "billing", "orders", "orders.billing.retry.10ms"))
+	assert.NoError(t, broker.WaitConsumed(ctx, "shipping", "orders"))
+
+	mu.Lock()
+	assert.Equal(t, []int{0, 1}, attempts["billing"])
+	assert.Equal(t, []int{0}, attempts["shipping"])
+	mu.Unlock()
+	assert.Len(t, broker.Messages("orders.billing.retry.10ms"), 1)
+	assert.Empty(t, broker.Messages("orders.shipping.retry.10ms"))
+	assert.Empty(t, broker.Messages("orders.billing.dlq"))
+}
+
+func TestBrokerRebalance(t *testing.T) {
+	broker := NewBroker(nil)
+	assert.NoError(t, broker.CreateTopic("orders", 4))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	consumed := 0
+	handler := kafka.MessageHandlerFunc(func(m *kafka.MessageDecoder) error {
+		mu.Lock()
+		consumed++
+		mu.Unlock()
+		m.Commit(m)
+		return nil
+	})
+	first, stopFirst := context.WithCancel(ctx)
+	for _, c := range []context.Context{first, ctx} {
+		go func(c context.Context) {
+			_ = broker.Consumer().Subscribe(&kafka.ConsumerContext{
+				Context: c, GroupID: "billing", Topics: []string{"orders"}, Processor: handler,
+			})
+		}(c)
+	}
+
+	publish(t, broker.Producer(), "orders", "a", "b", "c", "d")
+	assert.NoError(t, broker.WaitConsumed(ctx, "billing", "orders"))
+
+	// the partitions of the member leaving move to the other one
+	stopFirst()
+	publish(t, broker.Producer(), "orders", "e", "f", "g", "h")
+	assert.NoError(t, broker.WaitConsumed(ctx, "billing", "orders"))
+	mu.Lock()
+	assert.Equal(t, 8, consumed)
+	mu.Unlock()
+}
+
+func TestBrokerScheduledDelivery(t *testing.T) {
+	broker := NewBroker(nil)
+	scheduler := &kafka.Scheduler{Producer: broker.Producer(), Delays: []time.Duration{100 * time.Millisecond}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		_ = broker.Consumer().Subscribe(scheduler.ConsumerContext(ctx, "scheduler", []string{"orders"}))
+	}()
+
+	deliverAt := time.Now().Add(250 * time.Millisecond)
+	err := scheduler.PublishAt(ctx, &kafka.MessageContext{
+		Topic:   "orders",
+		Key:     []byte("a"),
+		Headers: map[string]string{"X-Tenant": "kaj"},
+		Value:   &kafka.BodyStateful{Body: order{ID: "a"}, Message: "reminder"},
+	}, deliverAt)
+	assert.NoError(t, err)
+	assert.Empty(t, broker.Messages("orders"))
+
+	assert.Eventually(t, func() bool { return len(broker.Messages("orders")) == 1 }, 3*time.Second, 10*time.Millisecond)
+	delivered := broker.Messages("orders")[0]
+	assert.False(t, delivered.Timestamp.Before(deliverAt), "delivered before its time")
+	assert.Equal(t, "kaj", delivered.Headers["X-Tenant"])
+	assert.Empty(t, delivered.Headers[kafka.HeaderDeliverAt])
+	assert.Empty(t, delivered.Headers[kafka.HeaderDeliverTopic])
+	var o order
+	assert.NoError(t, delivered.Unmarshal(&o))
+	assert.Equal(t, "a", o.ID)
+	// the wait is longer than the delay, the message went through it again
+	assert.Greater(t, len(broker.Messages("orders.delay.100ms")), 1)
+}
+
+func TestBrokerScheduledHop(t *testing.T) {
+	broker := NewBroker(nil)
+	scheduler := &kafka.Scheduler{
+		Producer: broker.Producer(),
+		Delays:   []time.Duration{100 * time.Millisecond, 300 * time.Millisecond},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		_ = broker.Consumer().Subscribe(scheduler.ConsumerContext(ctx, "scheduler", []string{"orders"}))
+	}()
+
+	deliverAt := time.Now().Add(400 * time.Millisecond)
+	err := scheduler.PublishAt(ctx, &kafka.MessageContext{
+		Topic: "orders",
+		Key:   []byte("a"),
+		Value: &kafka.BodyStateful{Body: order{ID: "a"}},
+	}, deliverAt)
+	assert.NoError(t, err)
+
+	// the longest delay first, the rest of the wait in the shorter one
+	assert.Eventually(t, func() bool { return len(broker.Messages("orders")) == 1 }, 3*time.Second, 10*time.Millisecond)
+	assert.Len(t, broker.Messages("orders.delay.300ms"), 1)
+	hops := broker.Messages("orders.delay.100ms")
+	if assert.Len(t, hops, 1) {
+		assert.Equal(t, "orders", hops[0].Headers[kafka.HeaderDeliverTopic])
+		assert.Equal(t, deliverAt.UTC().Format(time.RFC3339Nano), hops[0].Headers[kafka.HeaderDeliverAt])
+	}
+	delivered := broker.Messages("orders")[0]
+	assert.False(t, delivered.Timestamp.Before(deliverAt), "delivered before its time")
+	assert.NotContains(t, delivered.Headers, kafka.HeaderDeliverAt)
+	assert.NotContains(t, delivered.Headers, kafka.HeaderDeliverTopic)
+}
+
+func TestBrokerScheduledBadHeader(t *testing.T) {
+	broker := NewBroker(nil)
+	producer := broker.Producer()
+	scheduler := &kafka.Scheduler{Producer: producer, Delays: []time.Duration{100 * time.Millisecond}}
+
+	for _, headers := range []map[string]string{
+		{kafka.HeaderDeliverAt: "tomorrow", kafka.HeaderDeliverTopic: "orders"},
+		{kafka.HeaderDeliverAt: time.Now().Format(time.RFC3339Nano)},
+	} {
+		err := producer.Publish(context.Background(), &kafka.MessageContext{
+			Topic:   "orders.delay.100ms",
+			Headers: headers,
+			Codec:   kafka.RawCodec,
+			Value:   &kafka.BodyStateful{Body: []byte(`{"id":"a"}`)},
+		})
+		assert.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		_ = broker.Consumer().Subscribe(scheduler.ConsumerContext(ctx, "scheduler", []string{"orders"}))
+	}()
+
+	// both are dead lettered and committed instead of blocking the topic
+	assert.NoError(t, broker.WaitConsumed(ctx, "scheduler", "orders.delay.100ms"))
+	dead := broker.Messages("orders.delay.100ms.scheduler.dlq")
+	if assert.Len(t, dead, 2) {
+		assert.Equal(t, []byte(`{"id":"a"}`), dead[0].Value)
+		assert.Contains(t, dead[0].Headers[kafka.HeaderDecodeError], kafka.HeaderDeliverAt)
+		assert.Contains(t, dead[1].Headers[kafka.HeaderDecodeError], kafka.HeaderDeliverTopic)
+	}
+	assert.Empty(t, broker.Messages("orders"))
+}
+
+// TestSessionOffsets check the session marks and resets offsets like the
+// sarama partition offset manager
+func TestSessionOffsets(t *testing.T) {
+	b := NewBroker(nil)
+	assert.NoError(t, b.CreateTopic("orders", 1))
+	publish(t, b.Producer(), "orders", "a", "b", "c", "d", "e")
+	b.join("billing", []string{"orders"})
+
+	s := &session{
+		broker:  b,
+		ctx:     context.Background(),
+		groupID: "billing",
+		claims:  map[string][]int32{"orders": {0}},
+		offsets: map[string]map[int32]int64{},
+		initial: map[string]map[int32]int64{},
+	}
+	assert.NoError(t, s.init())
+	assert.Equal(t, int64(0), s.claim("orders", 0).InitialOffset())
+
+	// no committed offset, a reset is ignored
+	s.ResetOffset("orders", 0, 2, "")
+	assert.Equal(t, int64(-1), b.Committed("billing", "orders", 0))
+
+	s.MarkOffset("orders", 0, 3, "")
+	assert.Equal(t, int64(3), b.Committed("billing", "orders", 0))
+	// marking backward is ignored
+	s.MarkOffset("orders", 0, 1, "")
+	assert.Equal(t, int64(3), b.Committed("billing", "orders", 0))
+	// resetting forward is ignored
+	s.ResetOffset("orders", 0, 4, "")
+	assert.Equal(t, int64(3), b.Committed("billing", "orders", 0))
+	s.ResetOffset("orders", 0, 1, "")
+	assert.Equal(t, int64(1), b.Committed("billing", "orders", 0))
+	assert.Equal(t, int64(1), s.claim("orders", 0).InitialOffset())
+
+	// partitions not claimed are ignored
+	s.MarkOffset("orders", 1, 3, "")
+	assert.Equal(t, int64(-1), b.Committed("billing", "orders", 1))
+}
+
+func TestSubscribeStartAt(t *testing.T) {
+	b := NewBroker(nil)
+	assert.NoError(t, b.CreateTopic("orders", 1))
+	publish(t, b.Producer(), "orders", "a", "b", "c", "d")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	consumed := make(chan string, 10)
+	subscribe := func(group string) {
+		go func() {
+			_ = b.Consumer().Subscribe(&kafka.ConsumerContext{
+				Context:      ctx,
+				GroupID:      group,
+				Topics:       []string{"orders"},
+				StartOffsets: map[string]map[int32]int64{"orders": {0: 2}},
+				Processor: kafka.MessageHandlerFunc(func(m *kafka.MessageDecoder) error {
+					consumed <- string(m.Key)
+					m.Commit(m)
+					return nil
+				}),
+			})
+		}()
+	}
+
+	// a new group start forward of the oldest offset
+	subscribe("billing")
+	assert.NoError(t, b.WaitConsumed(ctx, "billing", "orders"))
+	assert.Equal(t, "c", <-consumed)
+	assert.Equal(t, "d", <-consumed)
+}
+
+func TestBrokerConcurrentCommitOnSuccess(t *testing.T) {
+	broker := NewBroker(nil)
+	assert.NoError(t, broker.CreateTopic("orders", 1))
+	publish(t, broker.Producer(), "orders", "a", "b", "c", "d", "e", "f")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		_ = broker.Consumer().Subscribe(&kafka.ConsumerContext{
+			Context:         ctx,
+			GroupID:         "billing",
+			Topics:          []string{"orders"},
+			Concurrency:     3,
+			CommitOnSuccess: true,
+			Processor: kafka.MessageHandlerFunc(func(m *kafka.MessageDecoder) error {
+				if string(m.Key) == "b" {
+					return errors.New("out of stock")
+				}
+				return nil
+			}),
+		})
+	}()
+
+	// the failed message does not hold back the commits of the next ones
+	assert.NoError(t, broker.WaitConsumed(ctx, "billing", "orders"))
+	assert.Equal(t, int64(6), broker.Committed("billing", "orders", 0))
+}
@@ -0,0 +1,363 @@
+package kafkatest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+
+	"github.com/Shopify/sarama"
+)
+
+// claimBufferSize messages fetched ahead of the handler per claim, like
+// sarama ChannelBufferSize
+const claimBufferSize = 256
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type group struct {
+	generation int32
+	members    []*member
+	committed  map[string]map[int32]int64
+	// running sessions per generation, a generation start once the
+	// sessions of the previous ones are over
+	running map[int32]int
+}
+
+type member struct {
+	id     string
+	topics []string
+}
+
+type consumer struct {
+	broker *Broker
+}
+
+// Subscribe join the group of ctx and consume its topics with the handler
+// kafka.CreateConsumerGroup use, until ctx.Context is cancelled. A member
+// joining or leaving the group end the sessions of the group and the
+// partitions are assigned again, like a kafka rebalance.
+func (c *consumer) Subscribe(ctx *kafka.ConsumerContext) error {
+	b := c.broker
+	handler, err := kafka.NewGroupHandler(ctx, b.cfg, b)
+	if err != nil {
+		return err
+	}
+
+	m := b.join(ctx.GroupID, ctx.SubscribedTopics())
+	defer b.leave(ctx.GroupID, m)
+
+	for ctx.Context.Err() == nil {
+		if err := b.session(ctx.Context, ctx.GroupID, m, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// join add a member subscribed to topics to group and rebalance it
+func (b *Broker) join(groupID string, topics []string) *member {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[groupID]
+	if !ok {
+		g = &group{committed: map[string]map[int32]int64{}, running: map[int32]int{}}
+		b.groups[groupID] = g
+	}
+	m := &member{id: fmt.Sprintf("%s-%d", groupID, g.generation+1), topics: topics}
+	g.members = append(g.members, m)
+	g.generation++
+	b.notify()
+	return m
+}
+
+// leave remove m from group and rebalance it
+func (b *Broker) leave(groupID string, m *member) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.groups[groupID]
+	for i, other := range g.members {
+		if other == m {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	g.generation++
+	b.notify()
+}
+
+// assign return the partitions of m, the partitions of every topic are dealt
+// in turn to the members subscribed to it. b.mu must be held.
+func (b *Broker) assign(g *group, m *member) map[string][]int32 {
+	claims := map[string][]int32{}
+	for _, tp := range b.sortedPartitions(m.topics) {
+		subscribed := make([]*member, 0, len(g.members))
+		for _, other := range g.members {
+			for _, topic := range other.topics {
+				if topic == tp.topic {
+					subscribed = append(subscribed, other)
+					break
+				}
+			}
+		}
+		if subscribed[int(tp.partition)%len(subscribed)] == m {
+			claims[tp.topic] = append(claims[tp.topic], tp.partition)
+		}
+	}
+	return claims
+}
+
+// session run a session of m until ctx is done or the group rebalance
+func (b *Broker) session(ctx context.Context, groupID string, m *member, handler sarama.ConsumerGroupHandler) error {
+	var generation int32
+	var claims map[string][]int32
+	err := b.wait(ctx, func() bool {
+		g := b.groups[groupID]
+		for gen, running := range g.running {
+			if gen < g.generation && running > 0 {
+				return false
+			}
+		}
+		generation = g.generation
+		claims = b.assign(g, m)
+		g.running[generation]++
+		return true
+	})
+	if err != nil {
+		return nil
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		b.mu.Lock()
+		b.groups[groupID].running[generation]--
+		b.notify()
+		b.mu.Unlock()
+	}()
+	go func() {
+		_ = b.wait(sessionCtx, func() bool { return b.groups[groupID].generation != generation })
+		cancel()
+	}()
+
+	s := &session{
+		broker:     b,
+		ctx:        sessionCtx,
+		groupID:    groupID,
+		memberID:   m.id,
+		generation: generation,
+		claims:     claims,
+		offsets:    map[string]map[int32]int64{},
+		initial:    map[string]map[int32]int64{},
+	}
+	if err := s.init(); err != nil {
+		return err
+	}
+	if err := handler.Setup(s); err != nil {
+		return fmt.Errorf("setup session of group %s got: %w", groupID, err)
+	}
+
+	var wg sync.WaitGroup
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			claim := s.claim(topic, partition)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				claim.fetch(sessionCtx)
+			}()
+			go func() {
+				defer wg.Done()
+				_ = handler.ConsumeClaim(s, claim)
+				// a claim returning early end the session like sarama
+				cancel()
+			}()
+		}
+	}
+	<-sessionCtx.Done()
+	wg.Wait()
+	return handler.Cleanup(s)
+}
+
+// session implement sarama.ConsumerGroupSession over the broker, marked
+// offsets are committed right away.
+type session struct {
+	broker     *Broker
+	ctx        context.Context
+	groupID    string
+	memberID   string
+	generation int32
+	claims     map[string][]int32
+	mu         sync.Mutex
+	// offsets committed offset per claimed partition, -1 when the group has
+	// none like the sarama partition offset manager
+	offsets map[string]map[int32]int64
+	// initial offset of the claims of the partitions without committed
+	// offset, from the initial offset of the consumer config
+	initial map[string]map[int32]int64
+}
+
+// init set the offsets of the claims from the committed offsets of the group
+// and the initial offset of the consumer config
+func (s *session) init() error {
+	b := s.broker
+	for topic, partitions := range s.claims {
+		s.offsets[topic] = map[int32]int64{}
+		s.initial[topic] = map[int32]int64{}
+		for _, partition := range partitions {
+			s.offsets[topic][partition] = b.Committed(s.groupID, topic, partition)
+			initial := int64(0)
+			if b.cfg.Consumer.InitialOffset == "newest" || b.cfg.Consumer.OffsetInitial == sarama.OffsetNewest {
+				newest, err := b.GetOffset(topic, partition, sarama.OffsetNewest)
+				if err != nil {
+					return err
+				}
+				initial = newest
+			}
+			s.initial[topic][partition] = initial
+		}
+	}
+	return nil
+}
+
+func (s *session) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *session) MemberID() string {
+	return s.memberID
+}
+
+func (s *session) GenerationID() int32 {
+	return s.generation
+}
+
+// MarkOffset commit offset as the next message to consume, like sarama it is
+// ignored unless it is ahead of the committed offset.
+func (s *session) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.offsets[topic][partition]; !ok || offset <= current {
+		return
+	}
+	s.commit(topic, partition, offset)
+}
+
+// ResetOffset commit offset as the next message to consume, like sarama it is
+// ignored unless it is at or behind the committed offset, so it never applies
+// to a partition without committed offset.
+func (s *session) ResetOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.offsets[topic][partition]; !ok || offset > current {
+		return
+	}
+	s.commit(topic, partition, offset)
+}
+
+// commit store offset in the session and the group. s.mu must be held.
+func (s *session) commit(topic string, partition int32, offset int64) {
+	s.offsets[topic][partition] = offset
+
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.groups[s.groupID]
+	if g.committed[topic] == nil {
+		g.committed[topic] = map[int32]int64{}
+	}
+	g.committed[topic][partition] = offset
+	b.notify()
+}
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// Commit is a no-op, marked offsets are already committed
+func (s *session) Commit() {}
+
+func (s *session) Context() context.Context {
+	return s.ctx
+}
+
+// claim return the claim of a partition starting at its committed offset, or
+// the initial offset without committed offset
+func (s *session) claim(topic string, partition int32) *claim {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	initial := s.offsets[topic][partition]
+	if initial < 0 {
+		initial = s.initial[topic][partition]
+	}
+	return &claim{
+		broker:    s.broker,
+		topic:     topic,
+		partition: partition,
+		initial:   initial,
+		messages:  make(chan *sarama.ConsumerMessage, claimBufferSize),
+	}
+}
+
+// claim implement sarama.ConsumerGroupClaim over a partition of the broker
+type claim struct {
+	broker    *Broker
+	topic     string
+	partition int32
+	initial   int64
+	messages  chan *sarama.ConsumerMessage
+}
+
+func (c *claim) Topic() string {
+	return c.topic
+}
+
+func (c *claim) Partition() int32 {
+	return c.partition
+}
+
+func (c *claim) InitialOffset() int64 {
+	return c.initial
+}
+
+func (c *claim) HighWaterMarkOffset() int64 {
+	newest, _ := c.broker.GetOffset(c.topic, c.partition, sarama.OffsetNewest)
+	return newest
+}
+
+func (c *claim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// fetch send the messages of the partition from the initial offset until ctx
+// is done, then close the messages channel.
+func (c *claim) fetch(ctx context.Context) {
+	defer close(c.messages)
+	offset := c.initial
+	for {
+		var batch []*sarama.ConsumerMessage
+		err := c.broker.wait(ctx, func() bool {
+			msgs := c.broker.topics[c.topic][c.partition]
+			if int64(len(msgs)) <= offset {
+				return false
+			}
+			batch = msgs[offset:]
+			return true
+		})
+		if err != nil {
+			return
+		}
+		for _, msg := range batch {
+			select {
+			case c.messages <- msg:
+				offset++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
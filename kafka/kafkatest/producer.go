@@ -0,0 +1,59 @@
+package kafkatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+)
+
+var errProducerClosed = errors.New("kafkatest producer is closed")
+
+type producer struct {
+	broker *Broker
+	mu     sync.Mutex
+	closed bool
+}
+
+// Publish encode msg with the codec of its topic and append it to the broker
+func (p *producer) Publish(ctx context.Context, msg *kafka.MessageContext) error {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return errProducerClosed
+	}
+
+	param, err := kafka.EncodeMessage(ctx, msg, p.broker.cfg.Codecs)
+	if err != nil {
+		return err
+	}
+	if err := p.broker.append(param); err != nil {
+		return fmt.Errorf("publish to topic: %s, id %v, got: %w", msg.Topic, msg.LogId, err)
+	}
+	return nil
+}
+
+// PublishBatch publish msgs one by one, the returned error lists every failed message
+func (p *producer) PublishBatch(ctx context.Context, msgs []*kafka.MessageContext) error {
+	failed := make([]string, 0)
+	for _, msg := range msgs {
+		if err := p.Publish(ctx, msg); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("publish batch failed %d messages: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (p *producer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
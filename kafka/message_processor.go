@@ -1,12 +1,14 @@
 package kafka
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"reflect"
 	"time"
 
 	"github.com/kiriminaja/kaj-golang-pkg/validates"
+
+	"github.com/getsentry/sentry-go"
 )
 
 type MessageProcessorFunc func(*MessageDecoder)
@@ -16,10 +18,19 @@ type MessageProcessor interface {
 	Processor(decoder *MessageDecoder) error
 }
 
+// MessageHandlerFunc adapter to use a function as MessageProcessor
+type MessageHandlerFunc func(*MessageDecoder) error
+
+// Processor call f(decoder)
+func (f MessageHandlerFunc) Processor(decoder *MessageDecoder) error {
+	return f(decoder)
+}
+
 // MessageDecoder decoder message data  on topic
 type MessageDecoder struct {
 	Body      []byte
 	Key       []byte
+	Headers   map[string]string
 	Message   string
 	Error     string
 	Source    *SourceData
@@ -28,14 +39,37 @@ type MessageDecoder struct {
 	TimeStamp time.Time
 	Offset    int64
 	Commit    func(*MessageDecoder)
+	ctx       context.Context
+	span      *sentry.Span
+	codec     Codec
 }
 
-// DecodeJSON decode kafka message byte to struct
+// Context return the context of the message, it carries the request id and
+// the trace of the publisher and is done when the consumer session ends.
+func (decoder *MessageDecoder) Context() context.Context {
+	if decoder.ctx == nil {
+		return context.Background()
+	}
+	return decoder.ctx
+}
+
+// finish end the consume transaction of the message
+func (decoder *MessageDecoder) finish() {
+	if decoder.span != nil {
+		decoder.span.Finish()
+	}
+}
+
+// Cast decode kafka message payload to struct with the codec of the topic
 func (decoder *MessageDecoder) Cast(out interface{}) error {
 	if reflect.TypeOf(out).Kind() != reflect.Ptr {
 		return fmt.Errorf("%s", "output destination cannot addressable")
 	}
-	err := json.Unmarshal(decoder.Body, out)
+	codec := decoder.codec
+	if codec == nil {
+		codec = EnvelopeCodec
+	}
+	err := codec.Unmarshal(decoder.Body, out)
 	if err != nil {
 		return err
 	}
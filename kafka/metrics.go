@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// Metrics record the activity of the producers and consumers built with
+// Config.Metrics, see NewPrometheusMetrics. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// Produced count a message published to topic, err is the publish error
+	Produced(topic string, err error)
+	// Consumed count a message of group received from a partition of topic,
+	// lag is the number of messages after it in the partition.
+	Consumed(group, topic string, partition int32, lag int64)
+	// Handled record the processing of messages of topic by the handler of
+	// group, err is the handler error.
+	Handled(group, topic string, d time.Duration, err error)
+	// Committed record an offset commit of group
+	Committed(group string, d time.Duration)
+}
+
+// saramaMetrics Metrics bridging the internal metrics of the sarama clients
+type saramaMetrics interface {
+	saramaRegistry() gometrics.Registry
+}
+
+// metricsOf return the Metrics of cfg, the report logger when
+// KAFKA_DEBUG_REPORT is set and no Metrics is configured.
+func metricsOf(cfg *Config) Metrics {
+	if cfg.Metrics != nil {
+		return cfg.Metrics
+	}
+	if KafkaDebugReport() {
+		return reportMetrics{}
+	}
+	return noopMetrics{}
+}
+
+// applyMetrics report the sarama metrics of config to the Metrics of cfg
+func applyMetrics(cfg *Config, config *sarama.Config) {
+	if m, ok := cfg.Metrics.(saramaMetrics); ok {
+		config.MetricRegistry = m.saramaRegistry()
+	}
+}
+
+// commit flush the marked offsets of session and record the commit latency
+func (c *consumerHandler) commit(session sarama.ConsumerGroupSession) {
+	started := time.Now()
+	session.Commit()
+	c.metrics.Committed(c.groupID, time.Since(started))
+}
+
+// lag return the number of messages after msg in its partition
+func lag(claim sarama.ConsumerGroupClaim, msg *sarama.ConsumerMessage) int64 {
+	l := claim.HighWaterMarkOffset() - msg.Offset - 1
+	if l < 0 {
+		return 0
+	}
+	return l
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Produced(string, error)                       {}
+func (noopMetrics) Consumed(string, string, int32, int64)        {}
+func (noopMetrics) Handled(string, string, time.Duration, error) {}
+func (noopMetrics) Committed(string, time.Duration)              {}
+
+// reportMetrics log every metric at debug level
+type reportMetrics struct{}
+
+func (reportMetrics) Produced(topic string, err error) {
+	logger.Debug(logger.SetMessageFormat("[kafka] produced to %s error %v", topic, err))
+}
+
+func (reportMetrics) Consumed(group, topic string, partition int32, lag int64) {
+	logger.Debug(logger.SetMessageFormat("[consumer] group %s consumed %s partition %d lag %d", group, topic, partition, lag))
+}
+
+func (reportMetrics) Handled(group, topic string, d time.Duration, err error) {
+	logger.Debug(logger.SetMessageFormat("[consumer] group %s handled %s in %s error %v", group, topic, d, err))
+}
+
+func (reportMetrics) Committed(group string, d time.Duration) {
+	logger.Debug(logger.SetMessageFormat("[consumer] group %s committed in %s", group, d))
+}
@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+var (
+	// saramaMetricName split the broker or topic of a sarama metric name,
+	// e.g. request-rate-for-broker-1 or record-send-rate-for-topic-orders
+	saramaMetricName = regexp.MustCompile(`^(.+)-for-(broker|topic)-(.+)$`)
+	saramaQuantiles  = []float64{0.5, 0.75, 0.95, 0.99}
+)
+
+type prometheusMetrics struct {
+	produced  *prometheus.CounterVec
+	consumed  *prometheus.CounterVec
+	lag       *prometheus.GaugeVec
+	handled   *prometheus.HistogramVec
+	committed *prometheus.HistogramVec
+	registry  gometrics.Registry
+}
+
+// NewPrometheusMetrics return Metrics registered in registerer under
+// namespace, with the sarama broker metrics of the clients built with it.
+//
+//	metrics, err := kafka.NewPrometheusMetrics("billing", prometheus.DefaultRegisterer)
+//	cfg.Metrics = metrics
+func NewPrometheusMetrics(namespace string, registerer prometheus.Registerer) (Metrics, error) {
+	m := &prometheusMetrics{
+		produced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "messages_produced_total",
+			Help:      "Messages published per topic and status.",
+		}, []string{"topic", "status"}),
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "messages_consumed_total",
+			Help:      "Messages received per consumer group and topic.",
+		}, []string{"group", "topic"}),
+		lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "consumer_lag",
+			Help:      "Messages after the last received message per partition.",
+		}, []string{"group", "topic", "partition"}),
+		handled: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "handler_duration_seconds",
+			Help:      "Message handler latency per consumer group, topic and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"group", "topic", "status"}),
+		committed: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "commit_duration_seconds",
+			Help:      "Offset commit latency per consumer group.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"group"}),
+		registry: gometrics.NewRegistry(),
+	}
+
+	collectors := []prometheus.Collector{
+		m.produced, m.consumed, m.lag, m.handled, m.committed,
+		&saramaCollector{namespace: namespace, registry: m.registry},
+	}
+	for _, c := range collectors {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// status return the status label of err
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func (m *prometheusMetrics) Produced(topic string, err error) {
+	m.produced.WithLabelValues(topic, status(err)).Inc()
+}
+
+func (m *prometheusMetrics) Consumed(group, topic string, partition int32, lag int64) {
+	m.consumed.WithLabelValues(group, topic).Inc()
+	m.lag.WithLabelValues(group, topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
+
+func (m *prometheusMetrics) Handled(group, topic string, d time.Duration, err error) {
+	m.handled.WithLabelValues(group, topic, status(err)).Observe(d.Seconds())
+}
+
+func (m *prometheusMetrics) Committed(group string, d time.Duration) {
+	m.committed.WithLabelValues(group).Observe(d.Seconds())
+}
+
+func (m *prometheusMetrics) saramaRegistry() gometrics.Registry {
+	return m.registry
+}
+
+// saramaCollector expose the go-metrics registry of sarama, the metrics are
+// read at every scrape so it is an unchecked collector.
+type saramaCollector struct {
+	namespace string
+	registry  gometrics.Registry
+}
+
+func (c *saramaCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *saramaCollector) Collect(ch chan<- prometheus.Metric) {
+	c.registry.Each(func(name string, metric interface{}) {
+		fqName, name, labels, values := c.describe(name)
+		desc := func(suffix, help string) *prometheus.Desc {
+			return prometheus.NewDesc(fqName+suffix, help, labels, nil)
+		}
+		switch m := metric.(type) {
+		case gometrics.Counter:
+			ch <- prometheus.MustNewConstMetric(desc("_total", "Sarama counter "+name), prometheus.CounterValue, float64(m.Count()), values...)
+		case gometrics.Gauge:
+			ch <- prometheus.MustNewConstMetric(desc("", "Sarama gauge "+name), prometheus.GaugeValue, float64(m.Value()), values...)
+		case gometrics.GaugeFloat64:
+			ch <- prometheus.MustNewConstMetric(desc("", "Sarama gauge "+name), prometheus.GaugeValue, m.Value(), values...)
+		case gometrics.Meter:
+			s := m.Snapshot()
+			ch <- prometheus.MustNewConstMetric(desc("_total", "Sarama meter count "+name), prometheus.CounterValue, float64(s.Count()), values...)
+			ch <- prometheus.MustNewConstMetric(desc("_rate1m", "Sarama meter one minute rate "+name), prometheus.GaugeValue, s.Rate1(), values...)
+		case gometrics.Histogram:
+			s := m.Snapshot()
+			percentiles := s.Percentiles(saramaQuantiles)
+			quantiles := make(map[float64]float64, len(saramaQuantiles))
+			for i, q := range saramaQuantiles {
+				quantiles[q] = percentiles[i]
+			}
+			ch <- prometheus.MustNewConstSummary(desc("", "Sarama histogram "+name), uint64(s.Count()), float64(s.Sum()), quantiles, values...)
+		}
+	})
+}
+
+// describe return the prometheus name, the name used in the help and the
+// labels of a sarama metric name. The per broker and per topic metrics get
+// their own family apart from the aggregated one, e.g.
+// sarama_broker_request_rate{broker="1"}, with the same help for every
+// broker or topic.
+func (c *saramaCollector) describe(name string) (string, string, []string, []string) {
+	subsystem := "sarama"
+	help := name
+	var labels, values []string
+	if match := saramaMetricName.FindStringSubmatch(name); match != nil {
+		name = match[1]
+		help = match[1] + " per " + match[2]
+		subsystem = "sarama_" + match[2]
+		labels = []string{match[2]}
+		values = []string{match[3]}
+	}
+	name = strings.ReplaceAll(name, "-", "_")
+	return prometheus.BuildFQName(c.namespace, subsystem, name), help, labels, values
+}
@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordMetrics count the produced and failed messages per topic
+type recordMetrics struct {
+	noopMetrics
+	mu       sync.Mutex
+	produced map[string]int
+	failed   map[string]int
+}
+
+func (m *recordMetrics) Produced(topic string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.produced == nil {
+		m.produced, m.failed = map[string]int{}, map[string]int{}
+	}
+	if err != nil {
+		m.failed[topic]++
+		return
+	}
+	m.produced[topic]++
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMetrics("billing", registry)
+	assert.NoError(t, err)
+
+	metrics.Consumed("svc", "orders", 2, 40)
+	metrics.Handled("svc", "orders", 20*time.Millisecond, nil)
+	metrics.Produced("orders", nil)
+
+	// sarama metrics of the clients built with cfg.Metrics
+	config := &Config{Metrics: metrics}
+	saramaConfig, err := newProducerConfig(config)
+	assert.NoError(t, err)
+	gometrics.GetOrRegisterMeter("request-rate-for-broker-1", saramaConfig.MetricRegistry).Mark(3)
+	gometrics.GetOrRegisterMeter("request-rate-for-broker-2", saramaConfig.MetricRegistry).Mark(2)
+	gometrics.GetOrRegisterMeter("record-send-rate-for-topic-orders", saramaConfig.MetricRegistry).Mark(1)
+	gometrics.GetOrRegisterMeter("record-send-rate-for-topic-invoices", saramaConfig.MetricRegistry).Mark(4)
+	gometrics.GetOrRegisterMeter("request-rate", saramaConfig.MetricRegistry).Mark(3)
+
+	expected := `
+# HELP billing_kafka_consumer_lag Messages after the last received message per partition.
+# TYPE billing_kafka_consumer_lag gauge
+billing_kafka_consumer_lag{group="svc",partition="2",topic="orders"} 40
+# HELP billing_sarama_broker_request_rate_total Sarama meter count request-rate per broker
+# TYPE billing_sarama_broker_request_rate_total counter
+billing_sarama_broker_request_rate_total{broker="1"} 3
+billing_sarama_broker_request_rate_total{broker="2"} 2
+# HELP billing_sarama_topic_record_send_rate_total Sarama meter count record-send-rate per topic
+# TYPE billing_sarama_topic_record_send_rate_total counter
+billing_sarama_topic_record_send_rate_total{topic="invoices"} 4
+billing_sarama_topic_record_send_rate_total{topic="orders"} 1
+# HELP billing_sarama_request_rate_total Sarama meter count request-rate
+# TYPE billing_sarama_request_rate_total counter
+billing_sarama_request_rate_total 3
+`
+	// every broker and topic share the help of their family
+	_, err = registry.Gather()
+	assert.NoError(t, err)
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"billing_kafka_consumer_lag", "billing_sarama_broker_request_rate_total",
+		"billing_sarama_topic_record_send_rate_total", "billing_sarama_request_rate_total"))
+	count, err := testutil.GatherAndCount(registry, "billing_kafka_handler_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,253 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	resetEarliest = "earliest"
+	resetLatest   = "latest"
+	resetTime     = "time"
+	resetShift    = "shift"
+)
+
+var errGroupActive = errors.New("kafka consumer group has active members, stop it before resetting offsets")
+
+// OffsetLookup resolve the offset of a partition at a time in milliseconds,
+// or sarama.OffsetOldest and sarama.OffsetNewest. It is a sarama.Client.
+type OffsetLookup interface {
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
+
+// GroupOffsetLookup resolve ConsumerContext.StartAt and StartOffsets, they
+// only apply to the partitions the group has no committed offset for.
+type GroupOffsetLookup interface {
+	OffsetLookup
+	// CommittedOffset return the offset committed by group on a partition,
+	// -1 when it has none.
+	CommittedOffset(group, topic string, partition int32) (int64, error)
+}
+
+// clientOffsets GroupOffsetLookup of a sarama client
+type clientOffsets struct {
+	sarama.Client
+}
+
+func (c clientOffsets) CommittedOffset(group, topic string, partition int32) (int64, error) {
+	coordinator, err := c.Coordinator(group)
+	if err != nil {
+		return 0, err
+	}
+	req := &sarama.OffsetFetchRequest{Version: 1, ConsumerGroup: group}
+	req.AddPartition(topic, partition)
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		return 0, err
+	}
+	block := resp.GetBlock(topic, partition)
+	if block == nil {
+		return -1, nil
+	}
+	if block.Err != sarama.ErrNoError {
+		return 0, block.Err
+	}
+	return block.Offset, nil
+}
+
+// OffsetReset target offset of Admin.ResetOffsets
+type OffsetReset struct {
+	kind  string
+	at    time.Time
+	shift int64
+}
+
+// ResetToEarliest move the group to the oldest retained message
+func ResetToEarliest() OffsetReset {
+	return OffsetReset{kind: resetEarliest}
+}
+
+// ResetToLatest move the group past the newest message, skipping the backlog
+func ResetToLatest() OffsetReset {
+	return OffsetReset{kind: resetLatest}
+}
+
+// ResetToTime move the group to the first message at or after at
+func ResetToTime(at time.Time) OffsetReset {
+	return OffsetReset{kind: resetTime, at: at}
+}
+
+// ResetShiftBy move the committed offsets by n, a negative n replay messages
+func ResetShiftBy(n int64) OffsetReset {
+	return OffsetReset{kind: resetShift, shift: n}
+}
+
+// OffsetChange committed offset of a partition moved by Admin.ResetOffsets
+type OffsetChange struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+}
+
+// offsetAt return the offset of the first message at or after at, the next
+// offset when every message is older.
+func offsetAt(offsets OffsetLookup, topic string, partition int32, at time.Time) (int64, error) {
+	offset, err := offsets.GetOffset(topic, partition, at.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return offsets.GetOffset(topic, partition, sarama.OffsetNewest)
+	}
+	return offset, nil
+}
+
+// resolve return the offset of partition for reset, committed is the offset
+// of the group or -1.
+func (r OffsetReset) resolve(offsets OffsetLookup, topic string, partition int32, committed int64) (int64, error) {
+	switch r.kind {
+	case resetEarliest:
+		return offsets.GetOffset(topic, partition, sarama.OffsetOldest)
+	case resetLatest:
+		return offsets.GetOffset(topic, partition, sarama.OffsetNewest)
+	case resetTime:
+		return offsetAt(offsets, topic, partition, r.at)
+	case resetShift:
+		oldest, err := offsets.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return 0, err
+		}
+		newest, err := offsets.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return 0, err
+		}
+		if committed < 0 {
+			committed = oldest
+		}
+		offset := committed + r.shift
+		if offset < oldest {
+			offset = oldest
+		}
+		if offset > newest {
+			offset = newest
+		}
+		return offset, nil
+	}
+	return 0, fmt.Errorf("unknown offset reset %q", r.kind)
+}
+
+// ResetOffsets commit the offsets of group on every partition of topic to
+// reset. The group must have no active member, its consumers pick up the
+// new offsets on their next start.
+func (k *admin) ResetOffsets(group, topic string, reset OffsetReset) ([]*OffsetChange, error) {
+	groups, err := k.admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		return nil, fmt.Errorf("describe group %s got: %w", group, err)
+	}
+	for _, g := range groups {
+		if len(g.Members) > 0 {
+			return nil, errGroupActive
+		}
+	}
+
+	partitions, err := k.client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("partitions of topic %s got: %w", topic, err)
+	}
+	committed, err := k.admin.ListConsumerGroupOffsets(group, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, fmt.Errorf("offsets of group %s got: %w", group, err)
+	}
+
+	// committed with a plain request, an offset manager only move the
+	// offsets backward
+	req := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1,
+	}
+	result := make([]*OffsetChange, 0, len(partitions))
+	for _, partition := range partitions {
+		from := int64(-1)
+		if block := committed.GetBlock(topic, partition); block != nil {
+			from = block.Offset
+		}
+		to, err := reset.resolve(k.client, topic, partition, from)
+		if err != nil {
+			return nil, fmt.Errorf("resolve offset of topic %s partition %d got: %w", topic, partition, err)
+		}
+
+		req.AddBlock(topic, partition, to, 0, "")
+		result = append(result, &OffsetChange{Topic: topic, Partition: partition, From: from, To: to})
+	}
+
+	coordinator, err := k.client.Coordinator(group)
+	if err != nil {
+		return nil, fmt.Errorf("coordinator of group %s got: %w", group, err)
+	}
+	resp, err := coordinator.CommitOffset(req)
+	if err != nil {
+		return nil, fmt.Errorf("commit offsets of group %s got: %w", group, err)
+	}
+	for _, partitions := range resp.Errors {
+		for partition, kerr := range partitions {
+			if kerr != sarama.ErrNoError {
+				return nil, fmt.Errorf("commit offset of group %s topic %s partition %d got: %w", group, topic, partition, kerr)
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Partition < result[j].Partition })
+	logger.Warn(logger.SetMessageFormat("[kafka] reset offsets of group %s topic %s to %s", group, topic, reset.kind))
+	return result, nil
+}
+
+// start move the partitions claimed by session to ConsumerContext.StartOffsets
+// or StartAt when the group has no committed offset for them, the committed
+// offsets of a restarted or rebalanced group are kept. Each partition is
+// checked once for the lifetime of the handler.
+func (c *consumerHandler) start(session sarama.ConsumerGroupSession) error {
+	if c.startAt.IsZero() && len(c.startOffsets) == 0 {
+		return nil
+	}
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			key := fmt.Sprintf("%s/%d", topic, partition)
+			if c.started[key] {
+				continue
+			}
+			committed, err := c.offsets.CommittedOffset(c.groupID, topic, partition)
+			if err != nil {
+				return fmt.Errorf("committed offset of topic %s partition %d got: %w", topic, partition, err)
+			}
+			c.started[key] = true
+			if committed >= 0 {
+				continue
+			}
+
+			offset, ok := c.startOffsets[topic][partition]
+			if !ok && !c.startAt.IsZero() {
+				offset, err = offsetAt(c.offsets, topic, partition, c.startAt)
+				if err != nil {
+					return fmt.Errorf("offset of topic %s partition %d at %s got: %w", topic, partition, c.startAt, err)
+				}
+				ok = true
+			}
+			if !ok {
+				continue
+			}
+			// the partition has no offset yet, marking it set the start
+			session.MarkOffset(topic, partition, offset, "")
+			logger.Info(logger.SetMessageFormat("[consumer] topic %s partition %d start at offset %d", topic, partition, offset))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOffsets partition retaining offsets 10 to 100, offset 50 at the
+// timestamp 5000
+type fakeOffsets struct{}
+
+func (fakeOffsets) GetOffset(_ string, _ int32, at int64) (int64, error) {
+	switch {
+	case at == sarama.OffsetOldest:
+		return 10, nil
+	case at == sarama.OffsetNewest:
+		return 100, nil
+	case at <= 5000:
+		return 50, nil
+	}
+	return -1, nil
+}
+
+func TestOffsetResetResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		reset     OffsetReset
+		committed int64
+		want      int64
+	}{
+		{"earliest", ResetToEarliest(), 60, 10},
+		{"latest", ResetToLatest(), 60, 100},
+		{"time", ResetToTime(time.UnixMilli(5000)), 60, 50},
+		{"time after newest", ResetToTime(time.UnixMilli(9000)), 60, 100},
+		{"shift back", ResetShiftBy(-20), 60, 40},
+		{"shift before oldest", ResetShiftBy(-100), 60, 10},
+		{"shift past newest", ResetShiftBy(100), 60, 100},
+		{"shift without committed", ResetShiftBy(5), -1, 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.reset.resolve(fakeOffsets{}, "orders", 0, tt.committed)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAdminResetOffsets(t *testing.T) {
+	tests := []struct {
+		name  string
+		reset OffsetReset
+		want  map[int32]int64
+	}{
+		// partition 0 committed 60, partition 1 has no committed offset
+		{"forward", ResetToLatest(), map[int32]int64{0: 100, 1: 100}},
+		{"backward", ResetShiftBy(-20), map[int32]int64{0: 40, 1: 10}},
+		{"uncommitted", ResetToEarliest(), map[int32]int64{0: 10, 1: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offsets := sarama.NewMockOffsetResponse(t)
+			for _, partition := range []int32{0, 1} {
+				offsets.SetOffset("orders", partition, sarama.OffsetOldest, 10).
+					SetOffset("orders", partition, sarama.OffsetNewest, 100)
+			}
+			broker, admin := mockAdmin(t, map[string]sarama.MockResponse{
+				"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t),
+				"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+					SetOffset("billing", "orders", 0, 60, "", sarama.ErrNoError),
+				"OffsetRequest":       offsets,
+				"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+			})
+
+			changes, err := admin.ResetOffsets("billing", "orders", tt.reset)
+			assert.NoError(t, err)
+			if assert.Len(t, changes, 2) {
+				assert.Equal(t, int64(60), changes[0].From)
+				assert.Equal(t, int64(-1), changes[1].From)
+			}
+
+			committed := map[int32]int64{}
+			for _, rr := range broker.History() {
+				req, ok := rr.Request.(*sarama.OffsetCommitRequest)
+				if !ok {
+					continue
+				}
+				for _, partition := range []int32{0, 1} {
+					offset, _, err := req.Offset("orders", partition)
+					assert.NoError(t, err)
+					committed[partition] = offset
+				}
+			}
+			assert.Equal(t, tt.want, committed)
+		})
+	}
+}
+
+func TestAdminResetOffsetsActiveGroup(t *testing.T) {
+	_, admin := mockAdmin(t, map[string]sarama.MockResponse{
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("billing", &sarama.GroupDescription{
+				GroupId: "billing",
+				State:   "Stable",
+				Members: map[string]*sarama.GroupMemberDescription{"member-1": {}},
+			}),
+	})
+	_, err := admin.ResetOffsets("billing", "orders", ResetToLatest())
+	assert.Equal(t, errGroupActive, err)
+}
+
+// startLookup fakeOffsets with the committed offsets of the group
+type startLookup struct {
+	fakeOffsets
+	committed map[int32]int64
+}
+
+func (l startLookup) CommittedOffset(_, _ string, partition int32) (int64, error) {
+	if offset, ok := l.committed[partition]; ok {
+		return offset, nil
+	}
+	return -1, nil
+}
+
+// startSession session claiming the partitions 0 and 1 of orders, the
+// marked and reset offsets are recorded.
+type startSession struct {
+	fakeSession
+	marked map[int32]int64
+	reset  map[int32]int64
+}
+
+func (s *startSession) MarkOffset(_ string, partition int32, offset int64, _ string) {
+	s.marked[partition] = offset
+}
+
+func (s *startSession) ResetOffset(_ string, partition int32, offset int64, _ string) {
+	s.reset[partition] = offset
+}
+
+func TestHandlerStart(t *testing.T) {
+	tests := []struct {
+		name      string
+		ctx       *ConsumerContext
+		committed map[int32]int64
+		want      map[int32]int64
+	}{
+		{"new group at time", &ConsumerContext{StartAt: time.UnixMilli(5000)}, nil, map[int32]int64{0: 50, 1: 50}},
+		{"new group forward of oldest", &ConsumerContext{StartOffsets: map[string]map[int32]int64{"orders": {0: 90}}}, nil, map[int32]int64{0: 90}},
+		{"committed kept", &ConsumerContext{StartAt: time.UnixMilli(5000)}, map[int32]int64{0: 70}, map[int32]int64{1: 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.ctx.GroupID = "billing"
+			handler := newConsumerHandler(tt.ctx, true)
+			handler.offsets = startLookup{committed: tt.committed}
+			session := &startSession{marked: map[int32]int64{}, reset: map[int32]int64{}}
+
+			assert.NoError(t, handler.start(session))
+			assert.Equal(t, tt.want, session.marked)
+			assert.Empty(t, session.reset)
+
+			// a new session of the same handler does not look up again
+			session.marked = map[int32]int64{}
+			assert.NoError(t, handler.start(session))
+			assert.Empty(t, session.marked)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"sync"
+)
+
+// offsetTracker mark the offsets of a claim processed out of order, an
+// offset is marked only once every lower offset is done so a commit never
+// skips a message still in progress.
+type offsetTracker struct {
+	mu      sync.Mutex
+	pending []int64
+	// done offsets not marked yet, true when acked and false when released
+	done map[int64]bool
+	mark func(offset int64)
+}
+
+func newOffsetTracker(mark func(offset int64)) *offsetTracker {
+	return &offsetTracker{
+		done: map[int64]bool{},
+		mark: mark,
+	}
+}
+
+// add register a dispatched offset, offsets are added in increasing order
+func (t *offsetTracker) add(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, offset)
+}
+
+// ack flag offset as done and mark the highest acked offset of the
+// contiguous done offsets
+func (t *offsetTracker) ack(offset int64) {
+	t.finish(offset, true)
+}
+
+// release flag offset as done without marking it, e.g. a failed message left
+// unmarked, the higher offsets are not held back by it. Like a sequential
+// claim it is marked past by the next acked offset.
+func (t *offsetTracker) release(offset int64) {
+	t.finish(offset, false)
+}
+
+func (t *offsetTracker) finish(offset int64, acked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) == 0 || offset < t.pending[0] {
+		return
+	}
+	if _, ok := t.done[offset]; !ok || acked {
+		t.done[offset] = acked
+	}
+
+	last := int64(-1)
+	for len(t.pending) > 0 {
+		head := t.pending[0]
+		acked, ok := t.done[head]
+		if !ok {
+			break
+		}
+		if acked {
+			last = head
+		}
+		delete(t.done, head)
+		t.pending = t.pending[1:]
+	}
+	if last >= 0 {
+		t.mark(last)
+	}
+}
+
+// inFlight number of dispatched offsets not marked yet
+func (t *offsetTracker) inFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
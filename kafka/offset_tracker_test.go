@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTracker(offsets ...int64) (*offsetTracker, *[]int64) {
+	marked := make([]int64, 0)
+	tracker := newOffsetTracker(func(offset int64) {
+		marked = append(marked, offset)
+	})
+	for _, o := range offsets {
+		tracker.add(o)
+	}
+	return tracker, &marked
+}
+
+func TestOffsetTracker(t *testing.T) {
+	tracker, marked := newTestTracker(10, 11, 12, 13)
+
+	tracker.ack(12)
+	tracker.ack(11)
+	assert.Empty(t, *marked)
+	assert.Equal(t, 4, tracker.inFlight())
+
+	tracker.ack(10)
+	assert.Equal(t, []int64{12}, *marked)
+	assert.Equal(t, 1, tracker.inFlight())
+
+	tracker.ack(13)
+	tracker.ack(13)
+	assert.Equal(t, []int64{12, 13}, *marked)
+	assert.Equal(t, 0, tracker.inFlight())
+}
+
+func TestOffsetTrackerRelease(t *testing.T) {
+	tracker, marked := newTestTracker(10, 11, 12, 13)
+
+	// a released head does not hold back the acked offsets after it
+	tracker.ack(11)
+	tracker.release(10)
+	assert.Equal(t, []int64{11}, *marked)
+	assert.Equal(t, 2, tracker.inFlight())
+
+	// released offsets are not marked on their own
+	tracker.release(13)
+	assert.Equal(t, []int64{11}, *marked)
+	tracker.ack(12)
+	assert.Equal(t, []int64{11, 12}, *marked)
+	assert.Equal(t, 0, tracker.inFlight())
+}
+
+func TestOffsetTrackerReleaseAfterAck(t *testing.T) {
+	tracker, marked := newTestTracker(30, 31)
+
+	// handle ack a message before it is released
+	tracker.ack(31)
+	tracker.release(31)
+	tracker.release(30)
+	assert.Equal(t, []int64{31}, *marked)
+	assert.Equal(t, 0, tracker.inFlight())
+}
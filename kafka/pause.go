@@ -0,0 +1,224 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+)
+
+// allPartitions wildcard of a topic paused without partitions
+const allPartitions = int32(-1)
+
+// pausable is the pause API of sarama.ConsumerGroup
+type pausable interface {
+	Pause(partitions map[string][]int32)
+	Resume(partitions map[string][]int32)
+	PauseAll()
+	ResumeAll()
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// ConsumerControl pause and resume the partitions of a running Subscribe
+// without leaving the group, see ConsumerContext.Control. Paused partitions
+// stay assigned to the member and stay paused through rebalances until
+// resumed.
+type ConsumerControl struct {
+	mu     sync.Mutex
+	group  pausable
+	all    bool
+	paused map[string]map[int32]bool
+	// claimed partitions of the running sessions and whether they are
+	// paused in the sarama group
+	claimed map[topicPartition]bool
+	// pressure is set while the in-flight messages are over maxInFlight
+	pressure    bool
+	inFlight    int
+	maxInFlight int
+	// changed is closed and replaced when a partition is paused or resumed
+	changed chan struct{}
+}
+
+// NewConsumerControl return a control to give to ConsumerContext.Control
+func NewConsumerControl() *ConsumerControl {
+	return &ConsumerControl{
+		paused:  map[string]map[int32]bool{},
+		claimed: map[topicPartition]bool{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Pause stop consuming partitions per topic, a topic without partitions is
+// paused entirely. The messages in progress are finished.
+func (c *ConsumerControl) Pause(partitions map[string][]int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, ids := range partitions {
+		if c.paused[topic] == nil {
+			c.paused[topic] = map[int32]bool{}
+		}
+		if len(ids) == 0 {
+			c.paused[topic][allPartitions] = true
+		}
+		for _, id := range ids {
+			c.paused[topic][id] = true
+		}
+	}
+	logger.Warn(logger.SetMessageFormat("[consumer] paused %v", partitions))
+	c.apply()
+}
+
+// Resume consume again partitions paused by Pause, a topic without
+// partitions is resumed entirely.
+func (c *ConsumerControl) Resume(partitions map[string][]int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, ids := range partitions {
+		if len(ids) == 0 {
+			delete(c.paused, topic)
+		}
+		for _, id := range ids {
+			delete(c.paused[topic], id)
+		}
+	}
+	logger.Info(logger.SetMessageFormat("[consumer] resumed %v", partitions))
+	c.apply()
+}
+
+// PauseAll stop consuming every partition, including the ones assigned later
+func (c *ConsumerControl) PauseAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.all = true
+	logger.Warn("[consumer] paused all partitions")
+	c.apply()
+}
+
+// ResumeAll consume again every partition paused by Pause or PauseAll
+func (c *ConsumerControl) ResumeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.all = false
+	c.paused = map[string]map[int32]bool{}
+	logger.Info("[consumer] resumed all partitions")
+	c.apply()
+}
+
+// Paused tell whether a partition is paused by Pause, PauseAll or backpressure
+func (c *ConsumerControl) Paused(topic string, partition int32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isPaused(topic, partition)
+}
+
+// isPaused c.mu must be held
+func (c *ConsumerControl) isPaused(topic string, partition int32) bool {
+	return c.all || c.pressure || c.paused[topic][allPartitions] || c.paused[topic][partition]
+}
+
+// apply pause and resume the claimed partitions in the sarama group and wake
+// the claims waiting in wait. c.mu must be held.
+func (c *ConsumerControl) apply() {
+	pause := map[string][]int32{}
+	resume := map[string][]int32{}
+	for tp, paused := range c.claimed {
+		should := c.isPaused(tp.topic, tp.partition)
+		if should == paused {
+			continue
+		}
+		c.claimed[tp] = should
+		if should {
+			pause[tp.topic] = append(pause[tp.topic], tp.partition)
+		} else {
+			resume[tp.topic] = append(resume[tp.topic], tp.partition)
+		}
+	}
+	if c.group != nil && len(pause) > 0 {
+		c.group.Pause(pause)
+	}
+	if c.group != nil && len(resume) > 0 {
+		c.group.Resume(resume)
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// attach apply the control to the sarama group of a Subscribe
+func (c *ConsumerControl) attach(group pausable, maxInFlight int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.group = group
+	c.maxInFlight = maxInFlight
+}
+
+// claim register a partition claimed by a session, it is paused right away
+// when it should be.
+func (c *ConsumerControl) claim(topic string, partition int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.claimed[topicPartition{topic: topic, partition: partition}] = false
+	c.apply()
+}
+
+// release forget a partition at the end of its claim
+func (c *ConsumerControl) release(topic string, partition int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.claimed, topicPartition{topic: topic, partition: partition})
+}
+
+// wait block while a partition is paused, false when ctx is done first
+func (c *ConsumerControl) wait(ctx context.Context, topic string, partition int32) bool {
+	for {
+		c.mu.Lock()
+		paused := c.isPaused(topic, partition)
+		changed := c.changed
+		c.mu.Unlock()
+		if !paused {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// watch return whether a partition is paused and a channel closed on the
+// next pause or resume
+func (c *ConsumerControl) watch(topic string, partition int32) (bool, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isPaused(topic, partition), c.changed
+}
+
+// received count n messages taken from the claims, every partition is
+// paused once maxInFlight messages are in progress.
+func (c *ConsumerControl) received(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight += n
+	if c.maxInFlight > 0 && !c.pressure && c.inFlight >= c.maxInFlight {
+		c.pressure = true
+		logger.Warn(logger.SetMessageFormat("[consumer] %d messages in flight, paused until %d", c.inFlight, c.maxInFlight/2))
+		c.apply()
+	}
+}
+
+// done count n messages finished, the partitions are resumed once the
+// in-flight messages are down to half of maxInFlight.
+func (c *ConsumerControl) done(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight -= n
+	if c.pressure && c.inFlight <= c.maxInFlight/2 {
+		c.pressure = false
+		logger.Info(logger.SetMessageFormat("[consumer] %d messages in flight, resumed", c.inFlight))
+		c.apply()
+	}
+}
@@ -0,0 +1,75 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeGroup record the partitions paused in the sarama group
+type fakeGroup struct {
+	paused map[string][]int32
+}
+
+func (g *fakeGroup) Pause(partitions map[string][]int32) {
+	for topic, ids := range partitions {
+		g.paused[topic] = append(g.paused[topic], ids...)
+	}
+}
+
+func (g *fakeGroup) Resume(partitions map[string][]int32) {
+	for topic := range partitions {
+		delete(g.paused, topic)
+	}
+}
+
+func (g *fakeGroup) PauseAll()  {}
+func (g *fakeGroup) ResumeAll() {}
+
+func TestConsumerControlPause(t *testing.T) {
+	group := &fakeGroup{paused: map[string][]int32{}}
+	control := NewConsumerControl()
+	control.attach(group, 0)
+	control.claim("orders", 0)
+	control.claim("payments", 1)
+
+	control.Pause(map[string][]int32{"orders": nil})
+	assert.True(t, control.Paused("orders", 0))
+	assert.True(t, control.Paused("orders", 7))
+	assert.False(t, control.Paused("payments", 1))
+	assert.Equal(t, map[string][]int32{"orders": {0}}, group.paused)
+
+	resumed := make(chan bool)
+	go func() {
+		resumed <- control.wait(context.Background(), "orders", 0)
+	}()
+	select {
+	case <-resumed:
+		t.Fatal("wait returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	control.Resume(map[string][]int32{"orders": nil})
+	assert.True(t, <-resumed)
+	assert.Empty(t, group.paused)
+
+	// a paused partition claimed by a later session is paused right away
+	control.PauseAll()
+	control.claim("refunds", 2)
+	assert.Equal(t, []int32{2}, group.paused["refunds"])
+}
+
+func TestConsumerControlBackpressure(t *testing.T) {
+	control := NewConsumerControl()
+	control.attach(nil, 4)
+
+	control.received(3)
+	assert.False(t, control.Paused("orders", 0))
+	control.received(1)
+	assert.True(t, control.Paused("orders", 0))
+	control.done(1)
+	assert.True(t, control.Paused("orders", 0), "resumed only at half of the limit")
+	control.done(1)
+	assert.False(t, control.Paused("orders", 0))
+}
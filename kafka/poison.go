@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrPoison wrapped by a Processor error, the message can never be processed
+// and is routed like a message that cannot be decoded instead of retried.
+var ErrPoison = errors.New("kafka poison message")
+
+// PoisonMessage message the codec of its topic cannot decode, or failed by a
+// Processor with ErrPoison, with its raw record value
+type PoisonMessage struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+	// Err decode error of the codec or Processor error wrapping ErrPoison
+	Err error
+}
+
+// PoisonHandlerFunc handle a message that cannot be decoded, e.g. to store
+// it for inspection. The message is skipped once it returns nil, an error is
+// retried until the session ends.
+type PoisonHandlerFunc func(ctx context.Context, msg *PoisonMessage) error
+
+func newPoisonMessage(msg *sarama.ConsumerMessage, err error) *PoisonMessage {
+	return &PoisonMessage{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   fromRecordHeaders(msg.Headers),
+		Timestamp: msg.Timestamp,
+		Err:       err,
+	}
+}
+
+// poison route a message failed to decode with cause to the poison handler,
+// or to the dead letter topic of the retry policy, otherwise it is logged and
+// skipped. False means the session ended before the message was routed.
+func (c *consumerHandler) poison(ctx context.Context, msg *sarama.ConsumerMessage, cause error) bool {
+	decodeError(msg, cause)
+	if c.poisonHandler == nil && c.retry == nil {
+		return true
+	}
+
+	poison := newPoisonMessage(msg, cause)
+	for {
+		err := c.routePoison(ctx, poison)
+		if err == nil {
+			return true
+		}
+		logger.Error(logger.SetMessageFormat("[consumer] topic %s partition %d offset %d route poison message got: %s",
+			msg.Topic, msg.Partition, msg.Offset, err.Error()))
+		if !wait(ctx, republishBackoff) {
+			return false
+		}
+	}
+}
+
+func (c *consumerHandler) routePoison(ctx context.Context, poison *PoisonMessage) error {
+	if c.poisonHandler != nil {
+		return c.poisonHandler(ctx, poison)
+	}
+	origin := c.origin(poison.Topic)
+	headers := make(map[string]string, len(poison.Headers)+2)
+	for k, v := range poison.Headers {
+		headers[k] = v
+	}
+	headers[HeaderRetryTopic] = origin
+	headers[HeaderDecodeError] = poison.Err.Error()
+
+	topic := c.retry.DeadLetterTopic(origin, c.groupID)
+	err := c.retry.Producer.Publish(ctx, &MessageContext{
+		Topic:   topic,
+		Key:     poison.Key,
+		Headers: headers,
+		// the raw value is kept as is, the codec of the topic cannot read it
+		Codec: RawCodec,
+		Value: &BodyStateful{Body: poison.Value},
+		LogId: poison.Offset,
+	})
+	if err != nil {
+		return fmt.Errorf("publish to %s got: %w", topic, err)
+	}
+	logger.Warn(logger.SetMessageFormat("[consumer] topic %s offset %d cannot be decoded, sent to %s", poison.Topic, poison.Offset, topic))
+	return nil
+}
@@ -0,0 +1,56 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoisonHandler(t *testing.T) {
+	var poisoned *PoisonMessage
+	processed := false
+	handler := newConsumerHandler(&ConsumerContext{
+		Processor: MessageHandlerFunc(func(m *MessageDecoder) error {
+			processed = true
+			return nil
+		}),
+		PoisonHandler: func(ctx context.Context, msg *PoisonMessage) error {
+			poisoned = msg
+			return nil
+		},
+	}, true)
+
+	acked := false
+	msg := &sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 7, Value: []byte("{not json")}
+	assert.True(t, handler.handle(&fakeSession{}, msg, func() { acked = true }))
+	assert.True(t, acked)
+	assert.False(t, processed)
+	if assert.NotNil(t, poisoned) {
+		assert.Equal(t, []byte("{not json"), poisoned.Value)
+		assert.Equal(t, int64(7), poisoned.Offset)
+		assert.Error(t, poisoned.Err)
+	}
+}
+
+func TestCommitOnSuccess(t *testing.T) {
+	var fail error
+	handler := newConsumerHandler(&ConsumerContext{
+		Processor: MessageHandlerFunc(func(m *MessageDecoder) error {
+			return fail
+		}),
+		CommitOnSuccess: true,
+	}, true)
+
+	msg := &sarama.ConsumerMessage{Topic: "orders", Value: []byte(`{"body":{"id":"a"}}`)}
+	acked := false
+	fail = errors.New("out of stock")
+	assert.True(t, handler.handle(&fakeSession{}, msg, func() { acked = true }))
+	assert.False(t, acked, "failed message is not marked")
+
+	fail = nil
+	assert.True(t, handler.handle(&fakeSession{}, msg, func() { acked = true }))
+	assert.True(t, acked)
+}
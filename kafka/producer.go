@@ -2,7 +2,7 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -25,62 +25,183 @@ var (
 		"random":     sarama.NewRandomPartitioner,
 		"manual":     sarama.NewManualPartitioner,
 	}
+
+	compressions = map[string]sarama.CompressionCodec{
+		"none":   sarama.CompressionNone,
+		"gzip":   sarama.CompressionGZIP,
+		"snappy": sarama.CompressionSnappy,
+		"lz4":    sarama.CompressionLZ4,
+		"zstd":   sarama.CompressionZSTD,
+	}
 )
 
 type producer struct {
 	config   *sarama.Config
 	brokers  []string
 	producer sarama.SyncProducer
+	codecs   codecs
+	metrics  Metrics
 }
 
 // SyncPublisher publish message  synchronously
-func (k *producer) Publish(_ context.Context, msg *MessageContext) error {
+func (k *producer) Publish(ctx context.Context, msg *MessageContext) error {
+	param, err := producerMessage(ctx, msg, k.codecs.of(msg))
+	if err != nil {
+		return err
+	}
+
+	partition, offset, err := k.producer.SendMessage(param)
+	k.metrics.Produced(msg.Topic, err)
+
+	if err != nil {
+		return fmt.Errorf("publish to topic: %s, partition %d, offset %d, id %v, got:%s ", msg.Topic, partition, offset, msg.LogId, err.Error())
+	}
+
+	if msg.Verbose {
+		logger.Info(fmt.Sprintf("publish to topic: %s,  partition: %d, offset: %d", msg.Topic, partition, offset), logger.SetField("msg", msg.Value))
+	}
+	return nil
+}
+
+// PublishBatch publish messages in a single call, the messages are grouped
+// per broker by sarama. The returned error lists every failed message.
+func (k *producer) PublishBatch(ctx context.Context, msgs []*MessageContext) error {
+	params := make([]*sarama.ProducerMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		param, err := producerMessage(ctx, msg, k.codecs.of(msg))
+		if err != nil {
+			return err
+		}
+		params = append(params, param)
+	}
+
+	err := k.producer.SendMessages(params)
+	var errs sarama.ProducerErrors
+	if errors.As(err, &errs) {
+		failed := make(map[*sarama.ProducerMessage]error, len(errs))
+		for _, e := range errs {
+			failed[e.Msg] = e.Err
+		}
+		for _, param := range params {
+			k.metrics.Produced(param.Topic, failed[param])
+		}
+		return publishBatchError(errs)
+	}
+	for _, param := range params {
+		k.metrics.Produced(param.Topic, err)
+	}
+	if err != nil {
+		return fmt.Errorf("publish batch of %d messages got: %w", len(msgs), err)
+	}
+	return nil
+}
+
+// Close flush and close the producer
+func (k *producer) Close() error {
+	return k.producer.Close()
+}
+
+// EncodeMessage build the sarama message of msg with the codec of its topic
+// in codecs, as published by Producer.
+func EncodeMessage(ctx context.Context, msg *MessageContext, topicCodecs map[string]Codec) (*sarama.ProducerMessage, error) {
+	return producerMessage(ctx, msg, codecs(topicCodecs).of(msg))
+}
+
+// producerMessage build the sarama message of msg with codec
+func producerMessage(ctx context.Context, msg *MessageContext, codec Codec) (*sarama.ProducerMessage, error) {
 	if msg.Value.Source == nil {
 		msg.Value.Source = &SourceData{
 			Service: os.Getenv("APP_NAME"),
 		}
 	}
-	value, _ := json.Marshal(msg.Value)
+	value, err := codec.Encode(msg.Value)
+	if err != nil {
+		return nil, fmt.Errorf("encode message to topic: %s, id %v, got: %w", msg.Topic, msg.LogId, err)
+	}
 	param := &sarama.ProducerMessage{
 		Topic:     msg.Topic,
-		Value:     sarama.StringEncoder(string(value)),
+		Value:     sarama.ByteEncoder(value),
 		Partition: msg.Partition,
 		Offset:    msg.Offset,
 		Timestamp: msg.TimeStamp,
+		Headers:   toRecordHeaders(injectHeaders(ctx, msg.Headers)),
+		Metadata:  msg,
 	}
 
 	if msg.Key != nil && len(msg.Key) > 0 {
 		param.Key = sarama.ByteEncoder(msg.Key)
 	}
+	return param, nil
+}
 
-	partition, offset, err := k.producer.SendMessage(param)
+func publishBatchError(errs sarama.ProducerErrors) error {
+	msg := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msg = append(msg, fmt.Sprintf("topic: %s, partition %d, got:%s", e.Msg.Topic, e.Msg.Partition, e.Err.Error()))
+	}
+	return fmt.Errorf("publish batch failed %d messages: %s", len(errs), strings.Join(msg, "; "))
+}
+
+// CreateProducer return message producer, ProducerConfig.Async build a
+// producer that does not wait for the broker and only logs failed messages,
+// use CreateAsyncProducer to receive the results. An invalid cfg return a
+// *ConfigError listing every invalid field.
+func CreateProducer(cfg *Config) (Producer, error) {
+	if cfg.Producer.Async {
+		m, err := newAsyncProducer(cfg, false)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
 
+	config, err := newProducerConfig(cfg)
 	if err != nil {
-		return fmt.Errorf("publish to topic: %s, partition %d, offset %d, id %v, got:%s ", msg.Topic, partition, offset, msg.LogId, err.Error())
+		return nil, err
 	}
 
-	if msg.Verbose {
-		logger.Info(fmt.Sprintf("publish to topic: %s,  partition: %d, offset: %d", msg.Topic, partition, offset), logger.SetField("msg", msg.Value))
+	syncProducer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Sarama producer: %w", err)
 	}
-	return nil
+
+	return &producer{
+		config:   config,
+		brokers:  cfg.Brokers,
+		producer: syncProducer,
+		codecs:   cfg.Codecs,
+		metrics:  metricsOf(cfg),
+	}, nil
 }
 
-// NewProducer return message producer
+// NewProducer return message producer, see CreateProducer.
+//
+// Deprecated: NewProducer exit the process on error, use CreateProducer.
 func NewProducer(cfg *Config) Producer {
+	m, err := CreateProducer(cfg)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	return m
+}
 
-	m := &producer{}
+// newProducerConfig build the sarama configuration of a producer
+func newProducerConfig(cfg *Config) (*sarama.Config, error) {
 	/**
 	 * Construct a new Sarama configuration.
 	 * The Kafka cluster version has to be defined before the consumer/producer is initialized.
 	 */
 	config := sarama.NewConfig()
+	problems := &ConfigError{}
+
 	if cfg.Version == "" {
 		cfg.Version = defaultVersion
 	}
 
 	version, err := sarama.ParseKafkaVersion(cfg.Version)
 	if err != nil {
-		logger.Fatal(fmt.Sprintf("parse kafka version got: %v", err))
+		problems.add("version", cfg.Version, "is not a kafka version")
 	}
 
 	config.Producer.Idempotent = cfg.Producer.IdemPotent
@@ -100,24 +221,12 @@ func NewProducer(cfg *Config) Producer {
 	strategy, ok := partitiions[cfg.Producer.PartitionStrategy]
 
 	if !ok {
-		logger.Fatal(logger.SetMessageFormat("[kafka] invalid producer partition strategy %s", cfg.Producer.PartitionStrategy))
+		problems.add("producer.partition_strategy", cfg.Producer.PartitionStrategy,
+			"must be one of hash, roundrobin, reference, random, manual")
 	}
 
-	if cfg.SASL.Enable {
-		config.Net.SASL.Enable = true
-		config.Net.SASL.User = cfg.SASL.User
-		config.Net.SASL.Password = cfg.SASL.Password
-		config.Net.SASL.Version = cfg.SASL.Version
-		config.Net.SASL.Handshake = cfg.SASL.Handshake
-		config.Net.SASL.Mechanism = sarama.SASLMechanism(cfg.SASL.Mechanism)
-		config.Net.TLS.Enable = true
-	}
-
-	// The TLS configuration to use for secure connections if
-	// enabled (defaults to nil).
-	if config.Net.TLS.Enable || cfg.TLS.Enable {
-		config.Net.TLS.Config = createTlsConfig(cfg.TLS)
-	}
+	applySecurity(cfg, config, problems)
+	applyMetrics(cfg, config)
 
 	config.Producer.Partitioner = strategy
 
@@ -130,19 +239,25 @@ func NewProducer(cfg *Config) Producer {
 		config.Producer.Timeout = defaultTimeout * time.Second
 	}
 
-	if cfg.ChannelBufferSize > 0 {
-		config.ChannelBufferSize = cfg.ChannelBufferSize
+	if len(strings.Trim(cfg.Producer.Compression, " ")) == 0 {
+		cfg.Producer.Compression = "none"
 	}
 
-	m.brokers = cfg.Brokers
-	m.config = config
+	compression, ok := compressions[cfg.Producer.Compression]
 
-	producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+	if !ok {
+		problems.add("producer.compression", cfg.Producer.Compression,
+			"must be one of none, gzip, snappy, lz4, zstd")
+	}
 
-	if err != nil {
-		logger.Fatal(fmt.Sprintf("failed to start Sarama producer:%s", err.Error()))
+	config.Producer.Compression = compression
+	config.Producer.Flush.Frequency = time.Duration(cfg.Producer.LingerMillisecond) * time.Millisecond
+	config.Producer.Flush.Messages = cfg.Producer.BatchSize
+	config.Producer.Flush.Bytes = cfg.Producer.BatchBytes
+
+	if cfg.ChannelBufferSize > 0 {
+		config.ChannelBufferSize = cfg.ChannelBufferSize
 	}
-	m.producer = producer
 
-	return m
+	return config, problems.err()
 }
@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProducerPublishBatch(t *testing.T) {
+	mock := mocks.NewSyncProducer(t, nil)
+	mock.ExpectSendMessageAndSucceed()
+	mock.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	metrics := &recordMetrics{}
+	p := &producer{producer: mock, metrics: metrics}
+
+	err := p.PublishBatch(context.Background(), []*MessageContext{
+		{Topic: "orders", Key: []byte("1"), Value: &BodyStateful{Body: "a"}},
+		{Topic: "orders", Key: []byte("2"), Value: &BodyStateful{Body: "b"}},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), sarama.ErrOutOfBrokers.Error())
+	// the mock fail the whole batch instead of returning sarama.ProducerErrors
+	assert.Equal(t, map[string]int{"orders": 2}, metrics.failed)
+	assert.NoError(t, p.Close())
+}
+
+func TestAsyncProducerResults(t *testing.T) {
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	mock := mocks.NewAsyncProducer(t, config)
+	mock.ExpectInputAndSucceed()
+	mock.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	p := wrapAsyncProducer(mock, config, true, noopMetrics{})
+
+	first := &MessageContext{Topic: "orders", Value: &BodyStateful{Body: "a"}}
+	second := &MessageContext{Topic: "orders", Value: &BodyStateful{Body: "b"}}
+	assert.NoError(t, p.PublishBatch(context.Background(), []*MessageContext{first, second}))
+
+	results := map[*MessageContext]error{}
+	for i := 0; i < 2; i++ {
+		r := <-p.Results()
+		results[r.Message] = r.Err
+	}
+	assert.NoError(t, results[first])
+	assert.Error(t, results[second])
+
+	assert.NoError(t, p.Close())
+	_, open := <-p.Results()
+	assert.False(t, open)
+}
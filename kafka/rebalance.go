@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+)
+
+// ConsumerSession group session of a consumer between two rebalances
+type ConsumerSession struct {
+	GroupID    string
+	MemberID   string
+	Generation int32
+	// Claims partitions per topic assigned to the member for the session
+	Claims map[string][]int32
+	// Context is done when the session ends
+	Context context.Context
+}
+
+// SessionFunc callback of the group session lifecycle, see
+// ConsumerContext.OnPartitionsAssigned
+type SessionFunc func(session *ConsumerSession)
+
+// rebalanceHooks callbacks of ConsumerContext
+type rebalanceHooks struct {
+	sessionStart SessionFunc
+	assigned     SessionFunc
+	revoked      SessionFunc
+	sessionEnd   SessionFunc
+}
+
+// consumerSession return the ConsumerSession of session
+func (c *consumerHandler) consumerSession(session sarama.ConsumerGroupSession) *ConsumerSession {
+	return &ConsumerSession{
+		GroupID:    c.groupID,
+		MemberID:   session.MemberID(),
+		Generation: session.GenerationID(),
+		Claims:     session.Claims(),
+		Context:    session.Context(),
+	}
+}
+
+// call run hook when it is set
+func (h SessionFunc) call(session *ConsumerSession) {
+	if h != nil {
+		h(session)
+	}
+}
+
+// setup start a session, the start offsets are applied before the
+// partitions are reported assigned.
+func (c *consumerHandler) setup(session sarama.ConsumerGroupSession) error {
+	s := c.consumerSession(session)
+	logger.Info(logger.SetMessageFormat("[consumer] group %s member %s generation %d assigned %v",
+		s.GroupID, s.MemberID, s.Generation, s.Claims))
+
+	c.hooks.sessionStart.call(s)
+	if err := c.start(session); err != nil {
+		return err
+	}
+	c.hooks.assigned.call(s)
+	return nil
+}
+
+// cleanup end a session once its claims are done, the revoked partitions
+// are reported before the marked offsets are committed.
+func (c *consumerHandler) cleanup(session sarama.ConsumerGroupSession) {
+	s := c.consumerSession(session)
+	c.hooks.revoked.call(s)
+	// flush the offsets marked during the session before leaving it
+	c.commit(session)
+	c.hooks.sessionEnd.call(s)
+
+	logger.Info(logger.SetMessageFormat("[consumer] group %s member %s generation %d revoked %v",
+		s.GroupID, s.MemberID, s.Generation, s.Claims))
+}
@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	committed bool
+}
+
+func (s *fakeSession) Claims() map[string][]int32 {
+	return map[string][]int32{"orders": {0, 1}}
+}
+
+func (s *fakeSession) MemberID() string {
+	return "member-1"
+}
+
+func (s *fakeSession) GenerationID() int32 {
+	return 3
+}
+
+func (s *fakeSession) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeSession) Commit() {
+	s.committed = true
+}
+
+func TestRebalanceHooks(t *testing.T) {
+	session := &fakeSession{}
+	calls := make([]string, 0)
+	hook := func(name string) SessionFunc {
+		return func(s *ConsumerSession) {
+			assert.Equal(t, "billing", s.GroupID)
+			assert.Equal(t, int32(3), s.Generation)
+			assert.Equal(t, []int32{0, 1}, s.Claims["orders"])
+			if name == "revoked" {
+				assert.False(t, session.committed, "revoked before the offsets are committed")
+			}
+			calls = append(calls, name)
+		}
+	}
+	handler := newConsumerHandler(&ConsumerContext{
+		GroupID:              "billing",
+		OnSessionStart:       hook("start"),
+		OnPartitionsAssigned: hook("assigned"),
+		OnPartitionsRevoked:  hook("revoked"),
+		OnSessionEnd:         hook("end"),
+	}, false)
+
+	assert.NoError(t, handler.Setup(session))
+	assert.NoError(t, handler.Cleanup(session))
+	assert.Equal(t, []string{"start", "assigned", "revoked", "end"}, calls)
+	assert.True(t, session.committed)
+}
@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	retryTopicInfix         = ".retry."
+	defaultDeadLetterSuffix = ".dlq"
+)
+
+// RetryPolicy republish the messages failed by a MessageProcessor to a delay
+// topic per attempt, "<topic>.<group>.retry.<delay>" (e.g.
+// orders.billing.retry.1m), and to the dead letter topic
+// "<topic>.<group>.dlq" once every delay is used. The topics are named after
+// the consumer group so groups consuming the same topic only retry their own
+// failures. The error and the attempt count travel in BodyStateful.Error and
+// BodyStateful.Source.
+type RetryPolicy struct {
+	// Producer publish to the retry and dead letter topics
+	Producer Producer
+	// Delays wait before each retry, an empty list send straight to the dead letter topic
+	Delays []time.Duration
+	// DeadLetterSuffix appended to the topic and group names, defaults to ".dlq"
+	DeadLetterSuffix string
+}
+
+type retryTopic struct {
+	origin string
+	delay  time.Duration
+}
+
+// RetryTopic return the delay topic name of topic for group
+func RetryTopic(topic, group string, delay time.Duration) string {
+	return topic + "." + group + retryTopicInfix + formatDelay(delay)
+}
+
+// DeadLetterTopic return the dead letter topic name of topic for group
+func (p *RetryPolicy) DeadLetterTopic(topic, group string) string {
+	if p.DeadLetterSuffix == "" {
+		return topic + "." + group + defaultDeadLetterSuffix
+	}
+	return topic + "." + group + p.DeadLetterSuffix
+}
+
+// Topics return the retry and dead letter topics of the given topics for
+// group, handy to create them before subscribing.
+func (p *RetryPolicy) Topics(group string, topics []string) []string {
+	result := make([]string, 0, len(topics)*(len(p.Delays)+1))
+	for _, t := range topics {
+		for _, d := range p.Delays {
+			result = append(result, RetryTopic(t, group, d))
+		}
+		result = append(result, p.DeadLetterTopic(t, group))
+	}
+	return result
+}
+
+// retryTopics map the delay topics of topics for group to their origin
+func (p *RetryPolicy) retryTopics(group string, topics []string) map[string]retryTopic {
+	result := map[string]retryTopic{}
+	for _, t := range topics {
+		for _, d := range p.Delays {
+			result[RetryTopic(t, group, d)] = retryTopic{origin: t, delay: d}
+		}
+	}
+	return result
+}
+
+// next return the topic of group receiving a message failed attempt times
+func (p *RetryPolicy) next(origin, group string, attempt int) string {
+	if attempt < len(p.Delays) {
+		return RetryTopic(origin, group, p.Delays[attempt])
+	}
+	return p.DeadLetterTopic(origin, group)
+}
+
+// formatDelay format delay as a topic name suffix: 30s, 10m, 1h
+func formatDelay(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d >= time.Second && d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	default:
+		return strings.ReplaceAll(d.String(), ".", "_")
+	}
+}
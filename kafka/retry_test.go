@@ -0,0 +1,28 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyTopics(t *testing.T) {
+	p := &RetryPolicy{Delays: []time.Duration{time.Minute, 10 * time.Minute, 2 * time.Hour}}
+
+	assert.Equal(t, "orders.billing.retry.1m", RetryTopic("orders", "billing", time.Minute))
+	assert.Equal(t, "orders.billing.retry.30s", RetryTopic("orders", "billing", 30*time.Second))
+	assert.Equal(t, "orders.billing.retry.90m", RetryTopic("orders", "billing", 90*time.Minute))
+
+	assert.Equal(t, "orders.billing.retry.1m", p.next("orders", "billing", 0))
+	assert.Equal(t, "orders.billing.retry.2h", p.next("orders", "billing", 2))
+	assert.Equal(t, "orders.billing.dlq", p.next("orders", "billing", 3))
+	assert.Equal(t, "orders.shipping.dlq", p.next("orders", "shipping", 3))
+
+	assert.Equal(t, []string{"orders.billing.retry.1m", "orders.billing.retry.10m", "orders.billing.retry.2h", "orders.billing.dlq"},
+		p.Topics("billing", []string{"orders"}))
+
+	rt := p.retryTopics("billing", []string{"orders"})["orders.billing.retry.10m"]
+	assert.Equal(t, "orders", rt.origin)
+	assert.Equal(t, 10*time.Minute, rt.delay)
+}
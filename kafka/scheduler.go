@@ -0,0 +1,225 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+)
+
+const delayTopicInfix = ".delay."
+
+var defaultScheduleDelays = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// errSessionDone stop the delivery of a message held when the session ends,
+// the handler leaves it unmarked without counting it as failed and the next
+// session consumes it again.
+var errSessionDone = errors.New("kafka session done")
+
+// Scheduler deliver messages at a given time over delay topics,
+// "<topic>.delay.<delay>" (e.g. orders.delay.1h). PublishAt send a message to
+// the longest delay topic within its wait, the consumer of ConsumerContext
+// hold it until the delay of its topic is elapsed, then send it to the next
+// delay topic or to its topic once due. A message is never delivered before
+// its time, it can be late by the lag of the scheduler consumer.
+type Scheduler struct {
+	// Producer publish to the delay topics and the target topics
+	Producer Producer
+	// Delays of the delay topics, defaults to 1m, 10m and 1h
+	Delays []time.Duration
+	// Codecs serialize the messages per target topic like Config.Codecs
+	Codecs map[string]Codec
+	// PoisonHandler receive the messages of the delay topics with a missing
+	// or invalid scheduling header, defaults to publishing them as is to the
+	// dead letter topic of their delay topic, e.g. orders.delay.1m.<group>.dlq.
+	PoisonHandler PoisonHandlerFunc
+}
+
+// DelayTopic return the delay topic name of topic
+func DelayTopic(topic string, delay time.Duration) string {
+	return topic + delayTopicInfix + formatDelay(delay)
+}
+
+// Topics return the delay topics of the given topics, handy to create them
+// before publishing.
+func (s *Scheduler) Topics(topics []string) []string {
+	delays := s.delays()
+	result := make([]string, 0, len(topics)*len(delays))
+	for _, t := range topics {
+		for _, d := range delays {
+			result = append(result, DelayTopic(t, d))
+		}
+	}
+	return result
+}
+
+// PublishAt publish msg to its topic at deliverAt, right away when deliverAt
+// is past. The value is serialized with the codec of the topic when
+// scheduled and delivered as is.
+func (s *Scheduler) PublishAt(ctx context.Context, msg *MessageContext, deliverAt time.Time) error {
+	wait := time.Until(deliverAt)
+	if wait <= 0 {
+		return s.Producer.Publish(ctx, msg)
+	}
+
+	param, err := EncodeMessage(ctx, msg, s.Codecs)
+	if err != nil {
+		return err
+	}
+	value, err := param.Value.Encode()
+	if err != nil {
+		return fmt.Errorf("encode message to topic: %s, id %v, got: %w", msg.Topic, msg.LogId, err)
+	}
+	headers := make(map[string]string, len(msg.Headers)+2)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderDeliverAt] = deliverAt.UTC().Format(time.RFC3339Nano)
+	headers[HeaderDeliverTopic] = msg.Topic
+
+	return s.Producer.Publish(ctx, &MessageContext{
+		Topic:   DelayTopic(msg.Topic, s.delayFor(wait)),
+		Key:     msg.Key,
+		Headers: headers,
+		Codec:   RawCodec,
+		Value:   &BodyStateful{Body: value},
+		LogId:   msg.LogId,
+		Verbose: msg.Verbose,
+	})
+}
+
+// ConsumerContext return the context consuming the delay topics of topics,
+// run it with Consumer.Subscribe in a group of its own:
+//
+//	go consumer.Subscribe(scheduler.ConsumerContext(ctx, "notification-scheduler", []string{"cod.reminder"}))
+//
+// The offset of a message is marked once it is published to its next topic,
+// a message with a bad scheduling header once it is sent to PoisonHandler.
+func (s *Scheduler) ConsumerContext(ctx context.Context, groupID string, topics []string) *ConsumerContext {
+	delays := map[string]time.Duration{}
+	for _, t := range topics {
+		for _, d := range s.delays() {
+			delays[DelayTopic(t, d)] = d
+		}
+	}
+	return &ConsumerContext{
+		Context:         ctx,
+		GroupID:         groupID,
+		Topics:          s.Topics(topics),
+		Codec:           RawCodec,
+		CommitOnSuccess: true,
+		Processor: MessageHandlerFunc(func(m *MessageDecoder) error {
+			return s.deliver(m, delays[m.Topic])
+		}),
+		PoisonHandler: s.poisonHandler(groupID),
+	}
+}
+
+// poisonHandler return Scheduler.PoisonHandler, or the handler publishing
+// to the dead letter topic of the delay topic.
+func (s *Scheduler) poisonHandler(groupID string) PoisonHandlerFunc {
+	if s.PoisonHandler != nil {
+		return s.PoisonHandler
+	}
+	dlq := &RetryPolicy{}
+	return func(ctx context.Context, msg *PoisonMessage) error {
+		headers := make(map[string]string, len(msg.Headers)+1)
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		headers[HeaderDecodeError] = msg.Err.Error()
+		topic := dlq.DeadLetterTopic(msg.Topic, groupID)
+		err := s.Producer.Publish(ctx, &MessageContext{
+			Topic:   topic,
+			Key:     msg.Key,
+			Headers: headers,
+			Codec:   RawCodec,
+			Value:   &BodyStateful{Body: msg.Value},
+			LogId:   msg.Offset,
+		})
+		if err != nil {
+			return fmt.Errorf("publish to %s got: %w", topic, err)
+		}
+		return nil
+	}
+}
+
+// deliver hold m until the delay of its topic is elapsed or it is due, then
+// publish it to the next delay topic or to its topic. It keeps trying until
+// the session ends, then fail with errSessionDone. A bad scheduling header
+// fail with ErrPoison.
+func (s *Scheduler) deliver(m *MessageDecoder, delay time.Duration) error {
+	deliverAt, err := time.Parse(time.RFC3339Nano, m.Headers[HeaderDeliverAt])
+	if err != nil {
+		return fmt.Errorf("%w, scheduled message header %s got: %s", ErrPoison, HeaderDeliverAt, err.Error())
+	}
+	topic := m.Headers[HeaderDeliverTopic]
+	if topic == "" {
+		return fmt.Errorf("%w, scheduled message without header %s", ErrPoison, HeaderDeliverTopic)
+	}
+
+	until := deliverAt
+	if elapsed := m.TimeStamp.Add(delay); !m.TimeStamp.IsZero() && elapsed.Before(until) {
+		until = elapsed
+	}
+	ctx := m.Context()
+	if !wait(ctx, time.Until(until)) {
+		return errSessionDone
+	}
+
+	headers := make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	msg := &MessageContext{
+		Key:     m.Key,
+		Headers: headers,
+		Codec:   RawCodec,
+		Value:   &BodyStateful{Body: m.Body},
+		LogId:   m.Offset,
+	}
+	if remaining := time.Until(deliverAt); remaining > 0 {
+		msg.Topic = DelayTopic(topic, s.delayFor(remaining))
+	} else {
+		msg.Topic = topic
+		delete(headers, HeaderDeliverAt)
+		delete(headers, HeaderDeliverTopic)
+	}
+
+	for {
+		err := s.Producer.Publish(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		logger.Error(logger.SetMessageFormat("[consumer] topic %s offset %d publish scheduled message to %s got: %s",
+			m.Topic, m.Offset, msg.Topic, err.Error()))
+		if !wait(ctx, republishBackoff) {
+			return errSessionDone
+		}
+	}
+}
+
+// delays return the delays of the delay topics in ascending order
+func (s *Scheduler) delays() []time.Duration {
+	if len(s.Delays) == 0 {
+		return defaultScheduleDelays
+	}
+	delays := append([]time.Duration{}, s.Delays...)
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	return delays
+}
+
+// delayFor return the longest delay within wait, or the shortest delay
+func (s *Scheduler) delayFor(wait time.Duration) time.Duration {
+	delays := s.delays()
+	delay := delays[0]
+	for _, d := range delays {
+		if d <= wait {
+			delay = d
+		}
+	}
+	return delay
+}
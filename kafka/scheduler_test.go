@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerTopics(t *testing.T) {
+	s := &Scheduler{Delays: []time.Duration{time.Hour, time.Minute, 10 * time.Minute}}
+
+	assert.Equal(t, "orders.delay.1h", DelayTopic("orders", time.Hour))
+	assert.Equal(t, []string{"orders.delay.1m", "orders.delay.10m", "orders.delay.1h"}, s.Topics([]string{"orders"}))
+
+	assert.Equal(t, time.Minute, s.delayFor(20*time.Second))
+	assert.Equal(t, 10*time.Minute, s.delayFor(59*time.Minute))
+	assert.Equal(t, time.Hour, s.delayFor(5*time.Hour))
+
+	ctx := s.ConsumerContext(context.Background(), "scheduler", []string{"orders"})
+	assert.Equal(t, s.Topics([]string{"orders"}), ctx.Topics)
+	assert.Equal(t, RawCodec, ctx.Codec)
+	assert.True(t, ctx.CommitOnSuccess)
+}
+
+// doneSession session whose context is done, as on a rebalance
+type doneSession struct {
+	fakeSession
+	ctx context.Context
+}
+
+func (s *doneSession) Context() context.Context {
+	return s.ctx
+}
+
+func TestSchedulerSessionDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &Scheduler{Delays: []time.Duration{time.Minute}}
+	handler := newConsumerHandler(s.ConsumerContext(ctx, "scheduler", []string{"orders"}), false)
+	msg := &sarama.ConsumerMessage{
+		Topic:     "orders.delay.1m",
+		Value:     []byte(`{"id":1}`),
+		Timestamp: time.Now(),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(HeaderDeliverAt), Value: []byte(time.Now().Add(time.Minute).Format(time.RFC3339Nano))},
+			{Key: []byte(HeaderDeliverTopic), Value: []byte("orders")},
+		},
+	}
+
+	// the held message is left for the next session, not acked nor failed
+	acked := false
+	assert.False(t, handler.handle(&doneSession{ctx: ctx}, msg, func() { acked = true }))
+	assert.False(t, acked)
+}
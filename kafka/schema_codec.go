@@ -0,0 +1,278 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// wireMagicByte first byte of the schema registry wire format
+	wireMagicByte = 0
+	// wireHeaderSize magic byte and big endian schema id
+	wireHeaderSize = 5
+)
+
+var (
+	errNoSchemaRegistry = errors.New("kafka schema codec requires a Registry")
+	errNoSchema         = errors.New("kafka schema codec requires a Schema and a Codec")
+)
+
+// SubjectNameStrategy return the registry subject of the schema of a topic
+// value or key, recordName is the fully qualified record or message name.
+type SubjectNameStrategy func(topic string, isKey bool, recordName string) string
+
+// TopicNameStrategy subject "<topic>-value" or "<topic>-key", the default
+func TopicNameStrategy(topic string, isKey bool, _ string) string {
+	if isKey {
+		return topic + "-key"
+	}
+	return topic + "-value"
+}
+
+// RecordNameStrategy subject named after the record, shared by topics
+func RecordNameStrategy(_ string, _ bool, recordName string) string {
+	return recordName
+}
+
+// TopicRecordNameStrategy subject "<topic>-<record>"
+func TopicRecordNameStrategy(topic string, _ bool, recordName string) string {
+	return topic + "-" + recordName
+}
+
+// SchemaCodecConfig codec of a topic registered in the schema registry
+type SchemaCodecConfig struct {
+	Registry SchemaRegistry
+	Topic    string
+	// Schema of the payload, its SchemaType tell the registry the format
+	Schema *Schema
+	// Codec serialize the payload, NewAvroCodec, ProtobufCodec or JSONCodec
+	Codec Codec
+	// SubjectStrategy defaults to TopicNameStrategy
+	SubjectStrategy SubjectNameStrategy
+	// RecordName fully qualified record or message name for the record
+	// subject strategies
+	RecordName string
+	// AutoRegister register Schema on the first publish, otherwise it must
+	// already be registered.
+	AutoRegister bool
+	// CheckCompatibility check Schema against the latest version of the
+	// subject on the first publish, an incompatible schema fail publishing.
+	CheckCompatibility bool
+	// AvroWriterSchema return the schema reading avro payloads written with
+	// another schema of the registry, e.g. a hamba/avro schema resolving the
+	// writer schema into Schema. Without it such payloads fail to decode
+	// rather than being read with the wrong schema.
+	AvroWriterSchema func(writer *Schema) (AvroSchema, error) `json:"-" yaml:"-"`
+}
+
+type schemaCodec struct {
+	cfg     *SchemaCodecConfig
+	subject string
+
+	mu       sync.Mutex
+	id       int
+	decoders map[int]Codec
+}
+
+// NewSchemaCodec return codec writing payloads in the schema registry wire
+// format: the magic byte, the schema id, the message indexes for protobuf
+// and the payload serialized by SchemaCodecConfig.Codec. Protobuf payloads
+// are written as the first message of the schema.
+//
+// The schema id is resolved on the first publish. Consumers read each payload
+// with the schema of its id, fetched from the registry once per id. Decoded
+// payloads keep their wire header so a retried message is republished with
+// the schema id it was written with.
+func NewSchemaCodec(cfg *SchemaCodecConfig) (Codec, error) {
+	if cfg.Registry == nil {
+		return nil, errNoSchemaRegistry
+	}
+	if cfg.Schema == nil || cfg.Codec == nil {
+		return nil, errNoSchema
+	}
+	if cfg.SubjectStrategy == nil {
+		cfg.SubjectStrategy = TopicNameStrategy
+	}
+	return &schemaCodec{
+		cfg:      cfg,
+		subject:  cfg.SubjectStrategy(cfg.Topic, false, cfg.RecordName),
+		decoders: map[int]Codec{},
+	}, nil
+}
+
+// schemaID resolve the id of the schema once, a failure is retried on the
+// next publish.
+func (c *schemaCodec) schemaID() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.id > 0 {
+		return c.id, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRegistryTimeout*time.Second)
+	defer cancel()
+
+	if c.cfg.CheckCompatibility {
+		ok, err := c.cfg.Registry.Compatible(ctx, c.subject, c.cfg.Schema)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("schema of topic %s is not compatible with subject %s", c.cfg.Topic, c.subject)
+		}
+	}
+
+	var (
+		id  int
+		err error
+	)
+	if c.cfg.AutoRegister {
+		id, err = c.cfg.Registry.Register(ctx, c.subject, c.cfg.Schema)
+	} else {
+		id, err = c.cfg.Registry.Lookup(ctx, c.subject, c.cfg.Schema)
+	}
+	if err != nil {
+		return 0, err
+	}
+	c.id = id
+	return id, nil
+}
+
+func (c *schemaCodec) Encode(body *BodyStateful) ([]byte, error) {
+	if raw, ok := rawPayload(body.Body); ok && c.isWireFormat(raw) {
+		// a consumed payload, e.g. a retry, keep the id it was written with
+		return raw, nil
+	}
+	id, err := c.schemaID()
+	if err != nil {
+		return nil, fmt.Errorf("schema id of subject %s got: %w", c.subject, err)
+	}
+	payload, err := c.cfg.Codec.Encode(body)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, wireHeaderSize, wireHeaderSize+1+len(payload))
+	data[0] = wireMagicByte
+	binary.BigEndian.PutUint32(data[1:], uint32(id))
+	if c.protobuf() {
+		// message indexes [0] are written as a single zero
+		data = append(data, 0)
+	}
+	return append(data, payload...), nil
+}
+
+// Decode check the wire header and the writer schema of data, the payload
+// is data itself so Unmarshal and a retry know the schema id.
+func (c *schemaCodec) Decode(data []byte) ([]byte, *BodyStateful, error) {
+	payload, codec, err := c.read(data)
+	if err != nil {
+		return nil, &BodyStateful{}, err
+	}
+	if _, body, err := codec.Decode(payload); err != nil {
+		return nil, body, err
+	}
+	return data, &BodyStateful{}, nil
+}
+
+func (c *schemaCodec) Unmarshal(payload []byte, out interface{}) error {
+	payload, codec, err := c.read(payload)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(payload, out)
+}
+
+func (c *schemaCodec) protobuf() bool {
+	return c.cfg.Schema.SchemaType == SchemaTypeProtobuf
+}
+
+// read strip the wire header of data, return the payload and the codec of
+// its schema id.
+func (c *schemaCodec) read(data []byte) ([]byte, Codec, error) {
+	payload, id, err := StripWireHeader(data, c.protobuf())
+	if err != nil {
+		return nil, nil, err
+	}
+	codec, err := c.writerCodec(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return payload, codec, nil
+}
+
+// isWireFormat report whether raw is already in the wire format of a schema
+// of the registry.
+func (c *schemaCodec) isWireFormat(raw []byte) bool {
+	_, _, err := c.read(raw)
+	return err == nil
+}
+
+// writerCodec return the codec of payloads written with the schema id, the
+// schema is fetched from the registry once. Protobuf and JSON payloads are
+// read by SchemaCodecConfig.Codec whatever their schema, avro payloads of
+// another schema need SchemaCodecConfig.AvroWriterSchema.
+func (c *schemaCodec) writerCodec(id int) (Codec, error) {
+	c.mu.Lock()
+	codec, ok := c.decoders[id]
+	c.mu.Unlock()
+	if ok {
+		return codec, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRegistryTimeout*time.Second)
+	defer cancel()
+	writer, err := c.cfg.Registry.Schema(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("schema id %d of topic %s got: %w", id, c.cfg.Topic, err)
+	}
+
+	codec = c.cfg.Codec
+	avro := c.cfg.Schema.SchemaType == "" || c.cfg.Schema.SchemaType == SchemaTypeAvro
+	if avro && writer.Schema != c.cfg.Schema.Schema {
+		if c.cfg.AvroWriterSchema == nil {
+			return nil, fmt.Errorf("payload of topic %s written with schema id %d, AvroWriterSchema is required to read it", c.cfg.Topic, id)
+		}
+		schema, err := c.cfg.AvroWriterSchema(writer)
+		if err != nil {
+			return nil, fmt.Errorf("writer schema id %d of topic %s got: %w", id, c.cfg.Topic, err)
+		}
+		codec = NewAvroCodec(schema)
+	}
+
+	c.mu.Lock()
+	c.decoders[id] = codec
+	c.mu.Unlock()
+	return codec, nil
+}
+
+// StripWireHeader return the payload and the schema id of a value in the
+// schema registry wire format, protobuf values also carry message indexes.
+func StripWireHeader(data []byte, protobuf bool) ([]byte, int, error) {
+	if len(data) < wireHeaderSize || data[0] != wireMagicByte {
+		return nil, 0, errors.New("value is not in schema registry wire format")
+	}
+	id := int(binary.BigEndian.Uint32(data[1:wireHeaderSize]))
+	data = data[wireHeaderSize:]
+	if !protobuf {
+		return data, id, nil
+	}
+
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 {
+		return nil, id, errors.New("invalid protobuf message indexes")
+	}
+	data = data[n:]
+	for i := int64(0); i < count; i++ {
+		_, n = binary.Varint(data)
+		if n <= 0 {
+			return nil, id, errors.New("invalid protobuf message indexes")
+		}
+		data = data[n:]
+	}
+	return data, id, nil
+}
@@ -0,0 +1,175 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/kiriminaja/kaj-golang-pkg/requester"
+)
+
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeJSON     = "JSON"
+
+	defaultRegistryTimeout = 5 // in second
+)
+
+// Schema registered in the schema registry, SchemaType is empty for avro
+type Schema struct {
+	Schema     string            `json:"schema"`
+	SchemaType string            `json:"schemaType,omitempty"`
+	References []SchemaReference `json:"references,omitempty"`
+}
+
+// SchemaReference schema imported by another one
+type SchemaReference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// SchemaRegistryError error response of the registry
+type SchemaRegistryError struct {
+	StatusCode int    `json:"-"`
+	Code       int    `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (e *SchemaRegistryError) Error() string {
+	return fmt.Sprintf("schema registry status %d, error %d: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// SchemaRegistry client of the Confluent Schema Registry, results are cached
+// since registered schemas are immutable.
+type SchemaRegistry interface {
+	// Register add schema to subject and return its id, a schema already
+	// registered keep its id.
+	Register(ctx context.Context, subject string, schema *Schema) (int, error)
+	// Lookup return the id of schema when it is registered under subject
+	Lookup(ctx context.Context, subject string, schema *Schema) (int, error)
+	// Schema return the schema of id
+	Schema(ctx context.Context, id int) (*Schema, error)
+	// Compatible check schema against the latest version of subject by the
+	// compatibility level of the subject, a new subject is compatible.
+	Compatible(ctx context.Context, subject string, schema *Schema) (bool, error)
+}
+
+type schemaRegistry struct {
+	cfg    *SchemaRegistryConfig
+	client requester.RequesterContract
+
+	mu      sync.RWMutex
+	ids     map[string]int
+	schemas map[int]*Schema
+}
+
+// NewSchemaRegistry return schema registry client
+func NewSchemaRegistry(cfg *SchemaRegistryConfig) SchemaRegistry {
+	if cfg.TimeoutSecond < 1 {
+		cfg.TimeoutSecond = defaultRegistryTimeout
+	}
+	return &schemaRegistry{
+		cfg:     cfg,
+		client:  requester.NewRequester(&requester.Config{Timeout: cfg.TimeoutSecond}),
+		ids:     make(map[string]int),
+		schemas: make(map[int]*Schema),
+	}
+}
+
+func (r *schemaRegistry) Register(ctx context.Context, subject string, schema *Schema) (int, error) {
+	return r.id(ctx, "/subjects/"+url.PathEscape(subject)+"/versions", subject, schema)
+}
+
+func (r *schemaRegistry) Lookup(ctx context.Context, subject string, schema *Schema) (int, error) {
+	return r.id(ctx, "/subjects/"+url.PathEscape(subject), subject, schema)
+}
+
+// id post schema to path returning its id, the ids are cached per subject
+func (r *schemaRegistry) id(ctx context.Context, path, subject string, schema *Schema) (int, error) {
+	key := subject + "\x00" + schema.SchemaType + "\x00" + schema.Schema
+	r.mu.RLock()
+	id, ok := r.ids[key]
+	r.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	result := &struct {
+		ID int `json:"id"`
+	}{}
+	if err := r.send(ctx, http.MethodPost, path, schema, result); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	r.ids[key] = result.ID
+	r.schemas[result.ID] = schema
+	r.mu.Unlock()
+	return result.ID, nil
+}
+
+func (r *schemaRegistry) Schema(ctx context.Context, id int) (*Schema, error) {
+	r.mu.RLock()
+	schema, ok := r.schemas[id]
+	r.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema = &Schema{}
+	if err := r.send(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, schema); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.schemas[id] = schema
+	r.mu.Unlock()
+	return schema, nil
+}
+
+func (r *schemaRegistry) Compatible(ctx context.Context, subject string, schema *Schema) (bool, error) {
+	result := &struct {
+		IsCompatible bool `json:"is_compatible"`
+	}{}
+	err := r.send(ctx, http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest", schema, result)
+	if e, ok := err.(*SchemaRegistryError); ok && e.StatusCode == http.StatusNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.IsCompatible, nil
+}
+
+func (r *schemaRegistry) send(ctx context.Context, method, path string, body, result interface{}) error {
+	regErr := &SchemaRegistryError{}
+	request := r.client.RAW().
+		SetContext(ctx).
+		SetHeader("Accept", "application/vnd.schemaregistry.v1+json, application/json").
+		SetSuccessResult(result).
+		SetErrorResult(regErr)
+	if r.cfg.User != "" {
+		request.SetBasicAuth(r.cfg.User, r.cfg.Password)
+	}
+	if body != nil {
+		request.SetBody(body)
+	}
+
+	response, err := request.Send(method, strings.TrimRight(r.cfg.URL, "/")+path)
+	if err != nil {
+		return fmt.Errorf("schema registry %s %s got: %w", method, path, err)
+	}
+	if response.IsErrorState() {
+		regErr.StatusCode = response.StatusCode
+		if regErr.Message == "" {
+			regErr.Message = response.String()
+		}
+		return regErr
+	}
+	return nil
+}
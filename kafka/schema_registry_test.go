@@ -0,0 +1,250 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// fakeRegistry in memory stand-in of the schema registry endpoints
+type fakeRegistry struct {
+	mu       sync.Mutex
+	schemas  []*Schema
+	subjects map[string][]int
+	requests int
+	// incompatible reject every compatibility check
+	incompatible bool
+}
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *httptest.Server) {
+	reg := &fakeRegistry{subjects: map[string][]int{}}
+	srv := httptest.NewServer(reg)
+	t.Cleanup(srv.Close)
+	return reg, srv
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	schema := &Schema{}
+	if r.Method == http.MethodPost {
+		_ = json.NewDecoder(r.Body).Decode(schema)
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case parts[0] == "compatibility":
+		if len(f.subjects[parts[2]]) == 0 {
+			f.notFound(w)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]bool{"is_compatible": !f.incompatible})
+	case parts[0] == "subjects" && len(parts) == 3:
+		id := f.find(schema)
+		if id == 0 {
+			f.schemas = append(f.schemas, schema)
+			id = len(f.schemas)
+		}
+		f.subjects[parts[1]] = append(f.subjects[parts[1]], id)
+		_ = json.NewEncoder(w).Encode(map[string]int{"id": id})
+	case parts[0] == "subjects":
+		for _, id := range f.subjects[parts[1]] {
+			if f.schemas[id-1].Schema == schema.Schema {
+				_ = json.NewEncoder(w).Encode(map[string]int{"id": id})
+				return
+			}
+		}
+		f.notFound(w)
+	case parts[0] == "schemas":
+		id, _ := strconv.Atoi(parts[2])
+		if id < 1 || id > len(f.schemas) {
+			f.notFound(w)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(f.schemas[id-1])
+	}
+}
+
+func (f *fakeRegistry) find(schema *Schema) int {
+	for i, s := range f.schemas {
+		if s.Schema == schema.Schema {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (f *fakeRegistry) notFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"error_code":40401,"message":"not found"}`))
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	reg, srv := newFakeRegistry(t)
+	client := NewSchemaRegistry(&SchemaRegistryConfig{URL: srv.URL})
+	ctx := context.Background()
+	schema := &Schema{Schema: `{"type":"string"}`}
+
+	_, err := client.Lookup(ctx, "orders-value", schema)
+	var regErr *SchemaRegistryError
+	assert.ErrorAs(t, err, &regErr)
+	assert.Equal(t, http.StatusNotFound, regErr.StatusCode)
+	assert.Equal(t, 40401, regErr.Code)
+
+	ok, err := client.Compatible(ctx, "orders-value", schema)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	id, err := client.Register(ctx, "orders-value", schema)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	// cached
+	requests := reg.requests
+	id, err = client.Register(ctx, "orders-value", schema)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+	got, err := client.Schema(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, schema.Schema, got.Schema)
+	assert.Equal(t, requests, reg.requests)
+}
+
+func TestSchemaCodec(t *testing.T) {
+	_, srv := newFakeRegistry(t)
+	codec, err := NewSchemaCodec(&SchemaCodecConfig{
+		Registry:           NewSchemaRegistry(&SchemaRegistryConfig{URL: srv.URL}),
+		Topic:              "orders",
+		Schema:             &Schema{Schema: `syntax = "proto3"; message StringValue { string value = 1; }`, SchemaType: SchemaTypeProtobuf},
+		Codec:              ProtobufCodec,
+		AutoRegister:       true,
+		CheckCompatibility: true,
+	})
+	assert.NoError(t, err)
+
+	data, err := codec.Encode(&BodyStateful{Body: wrapperspb.String("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 1, 0}, data[:6])
+
+	payload, _, err := codec.Decode(data)
+	assert.NoError(t, err)
+	out := &wrapperspb.StringValue{}
+	assert.NoError(t, codec.Unmarshal(payload, out))
+	assert.Equal(t, "hello", out.GetValue())
+
+	_, _, err = codec.Decode([]byte("plain"))
+	assert.Error(t, err)
+}
+
+func TestSchemaCodecIncompatible(t *testing.T) {
+	reg, srv := newFakeRegistry(t)
+	client := NewSchemaRegistry(&SchemaRegistryConfig{URL: srv.URL})
+	_, err := client.Register(context.Background(), "orders-value", &Schema{Schema: `{"type":"string"}`})
+	assert.NoError(t, err)
+	reg.incompatible = true
+
+	codec, err := NewSchemaCodec(&SchemaCodecConfig{
+		Registry:           client,
+		Topic:              "orders",
+		Schema:             &Schema{Schema: `{"type":"long"}`},
+		Codec:              JSONCodec,
+		AutoRegister:       true,
+		CheckCompatibility: true,
+	})
+	assert.NoError(t, err)
+	_, err = codec.Encode(&BodyStateful{Body: 1})
+	assert.Error(t, err)
+}
+
+// jsonAvroSchema stand-in of an avro schema writing JSON, rename map the
+// fields of a writer schema to the reader ones.
+type jsonAvroSchema struct {
+	rename map[string]string
+}
+
+func (s jsonAvroSchema) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (s jsonAvroSchema) Unmarshal(data []byte, v interface{}) error {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for from, to := range s.rename {
+		fields[to] = fields[from]
+		delete(fields, from)
+	}
+	data, _ = json.Marshal(fields)
+	return json.Unmarshal(data, v)
+}
+
+func TestSchemaCodecWriterSchema(t *testing.T) {
+	reg, srv := newFakeRegistry(t)
+	client := NewSchemaRegistry(&SchemaRegistryConfig{URL: srv.URL})
+	v1 := &Schema{Schema: `{"type":"record","name":"Order","fields":[{"name":"name","type":"string"}]}`}
+	v2 := &Schema{Schema: `{"type":"record","name":"Order","fields":[{"name":"full_name","type":"string"}]}`}
+
+	producer, err := NewSchemaCodec(&SchemaCodecConfig{
+		Registry: client, Topic: "orders", Schema: v1, Codec: NewAvroCodec(jsonAvroSchema{}), AutoRegister: true,
+	})
+	assert.NoError(t, err)
+	data, err := producer.Encode(&BodyStateful{Body: map[string]string{"name": "ann"}})
+	assert.NoError(t, err)
+
+	resolved := 0
+	consumer, err := NewSchemaCodec(&SchemaCodecConfig{
+		Registry: client, Topic: "orders", Schema: v2, Codec: NewAvroCodec(jsonAvroSchema{}), AutoRegister: true,
+		AvroWriterSchema: func(writer *Schema) (AvroSchema, error) {
+			resolved++
+			assert.Equal(t, v1.Schema, writer.Schema)
+			return jsonAvroSchema{rename: map[string]string{"name": "full_name"}}, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		payload, _, err := consumer.Decode(data)
+		assert.NoError(t, err)
+		out := map[string]string{}
+		assert.NoError(t, consumer.Unmarshal(payload, &out))
+		assert.Equal(t, map[string]string{"full_name": "ann"}, out)
+
+		// a retry republish the payload with the id it was written with
+		retried, err := consumer.Encode(&BodyStateful{Body: json.RawMessage(payload)})
+		assert.NoError(t, err)
+		assert.Equal(t, data, retried)
+	}
+	assert.Equal(t, 1, resolved, "writer schema is cached")
+
+	// a new payload is written with the id of the local schema
+	data, err = consumer.Encode(&BodyStateful{Body: map[string]string{"full_name": "bob"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 2}, data[:5])
+	assert.Len(t, reg.schemas, 2)
+
+	strict, err := NewSchemaCodec(&SchemaCodecConfig{
+		Registry: client, Topic: "orders", Schema: v2, Codec: NewAvroCodec(jsonAvroSchema{}),
+	})
+	assert.NoError(t, err)
+	_, _, err = strict.Decode(append([]byte{0, 0, 0, 0, 1}, `{"name":"ann"}`...))
+	assert.Error(t, err, "payload of another schema is not read with the local one")
+}
+
+func TestSubjectNameStrategy(t *testing.T) {
+	assert.Equal(t, "orders-value", TopicNameStrategy("orders", false, "com.Order"))
+	assert.Equal(t, "orders-key", TopicNameStrategy("orders", true, "com.Order"))
+	assert.Equal(t, "com.Order", RecordNameStrategy("orders", false, "com.Order"))
+	assert.Equal(t, "orders-com.Order", TopicRecordNameStrategy("orders", false, "com.Order"))
+}
@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// TokenProviderFunc adapter to use a function as OAUTHBEARER token provider
+type TokenProviderFunc func() (*sarama.AccessToken, error)
+
+// Token call f()
+func (f TokenProviderFunc) Token() (*sarama.AccessToken, error) {
+	return f()
+}
+
+// applySecurity set the SASL and TLS settings of cfg on config, shared by
+// the producers and the consumers.
+func applySecurity(cfg *Config, config *sarama.Config, problems *ConfigError) {
+	if cfg.SASL.Enable {
+		applySASL(cfg.SASL, config, problems)
+	}
+
+	// The TLS configuration to use for secure connections if
+	// enabled (defaults to nil).
+	if cfg.TLS.Enable {
+		tlsConfig, err := createTlsConfig(cfg.TLS)
+		problems.addError(err)
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+}
+
+func applySASL(cfg SASL, config *sarama.Config, problems *ConfigError) {
+	if cfg.Mechanism == "" {
+		cfg.Mechanism = sarama.SASLTypePlaintext
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Mechanism = sarama.SASLMechanism(cfg.Mechanism)
+	config.Net.SASL.Version = cfg.Version
+	config.Net.SASL.Handshake = !cfg.DisableHandshake
+	config.Net.SASL.User = cfg.User
+	config.Net.SASL.Password = cfg.Password
+
+	switch config.Net.SASL.Mechanism {
+	case sarama.SASLTypePlaintext:
+	case sarama.SASLTypeSCRAMSHA256:
+		config.Net.SASL.SCRAMAuthzID = cfg.SCRAMAuthzID
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &XDGSCRAMClient{HashGeneratorFcn: SHA256}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.SCRAMAuthzID = cfg.SCRAMAuthzID
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &XDGSCRAMClient{HashGeneratorFcn: SHA512}
+		}
+	case sarama.SASLTypeOAuth:
+		if cfg.TokenProvider == nil {
+			problems.add("sasl.token_provider", nil, "is required by "+sarama.SASLTypeOAuth)
+		}
+		config.Net.SASL.TokenProvider = cfg.TokenProvider
+		return
+	default:
+		problems.add("sasl.mechanism", cfg.Mechanism, fmt.Sprintf("must be one of %s, %s, %s, %s",
+			sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512, sarama.SASLTypeOAuth))
+		return
+	}
+
+	if cfg.User == "" {
+		problems.add("sasl.user", cfg.User, "is required by "+cfg.Mechanism)
+	}
+	if cfg.Password == "" {
+		problems.add("sasl.password", "", "is required by "+cfg.Mechanism)
+	}
+}
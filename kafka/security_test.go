@@ -0,0 +1,33 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySecurity(t *testing.T) {
+	config := sarama.NewConfig()
+	problems := &ConfigError{}
+	applySecurity(&Config{SASL: SASL{Enable: true, Mechanism: sarama.SASLTypeSCRAMSHA512, User: "u", Password: "p"}}, config, problems)
+	assert.NoError(t, problems.err())
+	assert.True(t, config.Net.SASL.Handshake)
+	assert.False(t, config.Net.TLS.Enable)
+	assert.IsType(t, &XDGSCRAMClient{}, config.Net.SASL.SCRAMClientGeneratorFunc())
+
+	problems = &ConfigError{}
+	applySecurity(&Config{SASL: SASL{Enable: true, Mechanism: sarama.SASLTypeOAuth}}, sarama.NewConfig(), problems)
+	assert.Equal(t, "sasl.token_provider", problems.Fields[0].Field)
+
+	config = sarama.NewConfig()
+	problems = &ConfigError{}
+	provider := TokenProviderFunc(func() (*sarama.AccessToken, error) {
+		return &sarama.AccessToken{Token: "t"}, nil
+	})
+	applySecurity(&Config{SASL: SASL{Enable: true, Mechanism: sarama.SASLTypeOAuth, TokenProvider: provider}}, config, problems)
+	assert.NoError(t, problems.err())
+	token, err := config.Net.SASL.TokenProvider.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "t", token.Token)
+}
@@ -0,0 +1,208 @@
+package kafka
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/Shopify/sarama"
+)
+
+// TransactionalProducer publish messages and consumed offsets atomically,
+// consumers reading with read_committed isolation only see committed messages.
+type TransactionalProducer interface {
+	Producer
+	BeginTxn() error
+	// SendOffsetsToTxn add the next offsets to consume per topic and
+	// partition, they are committed for groupID with the transaction.
+	SendOffsetsToTxn(offsets map[string]map[int32]int64, groupID string) error
+	CommitTxn() error
+	AbortTxn() error
+}
+
+// TxnProcessorFunc transform a consumed message and publish the results with
+// producer, everything it publishes is committed together with the message
+// offset, or aborted when it returns an error.
+type TxnProcessorFunc func(decoder *MessageDecoder, producer Producer) error
+
+type txnProducer struct {
+	*producer
+}
+
+// CreateTransactionalProducer return a producer publishing within
+// transactions, ProducerConfig.TransactionalID must be unique per producer
+// instance and stable through restarts.
+func CreateTransactionalProducer(cfg *Config) (TransactionalProducer, error) {
+	config, err := newProducerConfig(cfg)
+	if cfg.Producer.TransactionalID == "" {
+		problems, ok := err.(*ConfigError)
+		if !ok {
+			problems = &ConfigError{}
+		}
+		problems.add("producer.transactional_id", cfg.Producer.TransactionalID, "is required")
+		err = problems
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Net.MaxOpenRequests = 1
+	config.Producer.Transaction.ID = cfg.Producer.TransactionalID
+	if cfg.Producer.TransactionTimeoutSecond > 0 {
+		config.Producer.Transaction.Timeout = time.Duration(cfg.Producer.TransactionTimeoutSecond) * time.Second
+	}
+
+	syncProducer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Sarama transactional producer: %w", err)
+	}
+
+	return &txnProducer{
+		producer: &producer{
+			config:   config,
+			brokers:  cfg.Brokers,
+			producer: syncProducer,
+			codecs:   cfg.Codecs,
+			metrics:  metricsOf(cfg),
+		},
+	}, nil
+}
+
+// NewTransactionalProducer return a producer publishing within transactions,
+// see CreateTransactionalProducer.
+//
+// Deprecated: NewTransactionalProducer exit the process on error, use
+// CreateTransactionalProducer.
+func NewTransactionalProducer(cfg *Config) TransactionalProducer {
+	m, err := CreateTransactionalProducer(cfg)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	return m
+}
+
+func (k *txnProducer) BeginTxn() error {
+	return k.producer.producer.BeginTxn()
+}
+
+func (k *txnProducer) SendOffsetsToTxn(offsets map[string]map[int32]int64, groupID string) error {
+	param := make(map[string][]*sarama.PartitionOffsetMetadata, len(offsets))
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			param[topic] = append(param[topic], &sarama.PartitionOffsetMetadata{
+				Partition: partition,
+				Offset:    offset,
+			})
+		}
+	}
+	return k.producer.producer.AddOffsetsToTxn(param, groupID)
+}
+
+func (k *txnProducer) CommitTxn() error {
+	return k.producer.producer.CommitTxn()
+}
+
+func (k *txnProducer) AbortTxn() error {
+	return k.producer.producer.AbortTxn()
+}
+
+// consumeTxn process every message of the claim in its own transaction, the
+// message offset is committed by the transaction instead of the session.
+func (c *consumerHandler) consumeTxn(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		msg, ok := c.next(session, claim)
+		if !ok {
+			return nil
+		}
+		if !c.due(session, msg) {
+			c.control.done(1)
+			return nil
+		}
+		err := c.handleTxn(session, msg)
+		c.control.done(1)
+		if err != nil {
+			// the offset is not committed, stop the claim so the message
+			// is consumed again by the next session
+			logger.Error(logger.SetMessageFormat("[consumer] topic %s partition %d offset %d transaction error %s",
+				msg.Topic, msg.Partition, msg.Offset, err.Error()))
+			return err
+		}
+	}
+}
+
+// handleTxn process msg in a transaction of the producer shared by the
+// claims, the claims wait for each other's transaction.
+func (c *consumerHandler) handleTxn(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) error {
+	c.txnMu.Lock()
+	defer c.txnMu.Unlock()
+	producer := c.txnProducer
+	if err := producer.BeginTxn(); err != nil {
+		return err
+	}
+
+	decoder, bodyFull, err := c.decode(session.Context(), msg, func() {})
+	if err != nil {
+		// the transaction only commit the offset to skip the message
+		if !c.poison(session.Context(), msg, err) {
+			return abortTxn(producer, session.Context().Err())
+		}
+	} else {
+		defer decoder.finish()
+		if err := c.processTxn(decoder, bodyFull, msg); err != nil {
+			return err
+		}
+	}
+
+	if err := producer.SendOffsetsToTxn(map[string]map[int32]int64{
+		msg.Topic: {msg.Partition: msg.Offset + 1},
+	}, c.groupID); err != nil {
+		return abortTxn(producer, err)
+	}
+	started := time.Now()
+	if err := producer.CommitTxn(); err != nil {
+		return abortTxn(producer, err)
+	}
+	c.metrics.Committed(c.groupID, time.Since(started))
+	return nil
+}
+
+// processTxn run the processor in the current transaction, on error the
+// transaction is replaced by one routing the message by the retry policy.
+func (c *consumerHandler) processTxn(decoder *MessageDecoder, bodyFull *BodyStateful, msg *sarama.ConsumerMessage) error {
+	producer := c.txnProducer
+	started := time.Now()
+	cause := c.txnProcessor(decoder, producer)
+	c.metrics.Handled(c.groupID, msg.Topic, time.Since(started), cause)
+	if cause == nil {
+		return nil
+	}
+	if err := producer.AbortTxn(); err != nil {
+		return err
+	}
+	if c.retry == nil {
+		logger.Error(logger.SetMessageFormat("[consumer] topic %s partition %d offset %d processing error %s",
+			msg.Topic, msg.Partition, msg.Offset, cause.Error()))
+	}
+	// the failed message is routed and its offset committed in a new transaction
+	if err := producer.BeginTxn(); err != nil {
+		return err
+	}
+	if c.retry != nil {
+		if err := producer.Publish(decoder.Context(), c.retryMessage(c.origin(msg.Topic), decoder, bodyFull, cause)); err != nil {
+			return abortTxn(producer, err)
+		}
+	}
+	return nil
+}
+
+// abortTxn abort the transaction failed with cause
+func abortTxn(producer TransactionalProducer, cause error) error {
+	if err := producer.AbortTxn(); err != nil {
+		return fmt.Errorf("%s, abort transaction got: %w", cause.Error(), err)
+	}
+	return cause
+}
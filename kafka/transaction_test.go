@@ -0,0 +1,183 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTxnProducer run one transaction at a time like a sarama transactional
+// producer, messages and offsets are kept once committed.
+type fakeTxnProducer struct {
+	mu        sync.Mutex
+	inTxn     bool
+	pending   []*MessageContext
+	offsets   map[int32]int64
+	published []*MessageContext
+	committed map[int32]int64
+	aborted   int
+}
+
+func newFakeTxnProducer() *fakeTxnProducer {
+	return &fakeTxnProducer{committed: map[int32]int64{}}
+}
+
+func (p *fakeTxnProducer) Publish(_ context.Context, msg *MessageContext) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.inTxn {
+		return errors.New("publish outside a transaction")
+	}
+	p.pending = append(p.pending, msg)
+	return nil
+}
+
+func (p *fakeTxnProducer) PublishBatch(ctx context.Context, msgs []*MessageContext) error {
+	for _, msg := range msgs {
+		if err := p.Publish(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *fakeTxnProducer) Close() error {
+	return nil
+}
+
+func (p *fakeTxnProducer) BeginTxn() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inTxn {
+		return errors.New("transaction already in progress")
+	}
+	p.inTxn = true
+	p.pending = nil
+	p.offsets = map[int32]int64{}
+	return nil
+}
+
+func (p *fakeTxnProducer) SendOffsetsToTxn(offsets map[string]map[int32]int64, _ string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, partitions := range offsets {
+		for partition, offset := range partitions {
+			p.offsets[partition] = offset
+		}
+	}
+	return nil
+}
+
+func (p *fakeTxnProducer) CommitTxn() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.inTxn {
+		return errors.New("no transaction in progress")
+	}
+	p.inTxn = false
+	p.published = append(p.published, p.pending...)
+	for partition, offset := range p.offsets {
+		p.committed[partition] = offset
+	}
+	return nil
+}
+
+func (p *fakeTxnProducer) AbortTxn() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inTxn = false
+	p.aborted++
+	return nil
+}
+
+func txnMessage(partition int32, offset int64) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: partition,
+		Offset:    offset,
+		Value:     []byte(`{"payload":{"id":"a"},"message":"created"}`),
+		Timestamp: time.Now(),
+	}
+}
+
+func newTxnHandler(producer *fakeTxnProducer, retry *RetryPolicy, process TxnProcessorFunc) *consumerHandler {
+	return newConsumerHandler(&ConsumerContext{
+		GroupID:     "billing",
+		Topics:      []string{"orders"},
+		TxnHandler:  process,
+		TxnProducer: producer,
+		Retry:       retry,
+	}, false)
+}
+
+func TestHandleTxnCommit(t *testing.T) {
+	producer := newFakeTxnProducer()
+	handler := newTxnHandler(producer, nil, func(m *MessageDecoder, p Producer) error {
+		return p.Publish(m.Context(), &MessageContext{Topic: "invoices", Value: &BodyStateful{Body: m.Body}})
+	})
+
+	assert.NoError(t, handler.handleTxn(&fakeSession{}, txnMessage(0, 7)))
+	if assert.Len(t, producer.published, 1) {
+		assert.Equal(t, "invoices", producer.published[0].Topic)
+	}
+	assert.Equal(t, int64(8), producer.committed[0])
+	assert.Equal(t, 0, producer.aborted)
+}
+
+func TestHandleTxnAbort(t *testing.T) {
+	producer := newFakeTxnProducer()
+	handler := newTxnHandler(producer, nil, func(m *MessageDecoder, p Producer) error {
+		_ = p.Publish(m.Context(), &MessageContext{Topic: "invoices", Value: &BodyStateful{Body: m.Body}})
+		return errors.New("out of stock")
+	})
+
+	// the published messages are aborted, the offset is committed to skip
+	// the failed message
+	assert.NoError(t, handler.handleTxn(&fakeSession{}, txnMessage(0, 7)))
+	assert.Empty(t, producer.published)
+	assert.Equal(t, 1, producer.aborted)
+	assert.Equal(t, int64(8), producer.committed[0])
+}
+
+func TestHandleTxnRetry(t *testing.T) {
+	producer := newFakeTxnProducer()
+	retry := &RetryPolicy{Producer: producer, Delays: []time.Duration{time.Minute}}
+	handler := newTxnHandler(producer, retry, func(m *MessageDecoder, p Producer) error {
+		return errors.New("out of stock")
+	})
+
+	assert.NoError(t, handler.handleTxn(&fakeSession{}, txnMessage(0, 7)))
+	if assert.Len(t, producer.published, 1) {
+		assert.Equal(t, "orders.billing.retry.1m", producer.published[0].Topic)
+		assert.Equal(t, "out of stock", producer.published[0].Value.Error)
+	}
+	assert.Equal(t, int64(8), producer.committed[0])
+}
+
+func TestHandleTxnConcurrentClaims(t *testing.T) {
+	producer := newFakeTxnProducer()
+	handler := newTxnHandler(producer, nil, func(m *MessageDecoder, p Producer) error {
+		// keep the transaction open while the other claims start theirs
+		time.Sleep(100 * time.Microsecond)
+		return p.Publish(m.Context(), &MessageContext{Topic: "invoices", Value: &BodyStateful{Body: m.Body}})
+	})
+
+	var wg sync.WaitGroup
+	for partition := int32(0); partition < 4; partition++ {
+		wg.Add(1)
+		go func(partition int32) {
+			defer wg.Done()
+			for offset := int64(0); offset < 50; offset++ {
+				assert.NoError(t, handler.handleTxn(&fakeSession{}, txnMessage(partition, offset)))
+			}
+		}(partition)
+	}
+	wg.Wait()
+	assert.Len(t, producer.published, 200)
+	assert.Equal(t, map[int32]int64{0: 50, 1: 50, 2: 50, 3: 50}, producer.committed)
+}
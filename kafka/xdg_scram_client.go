@@ -8,8 +8,6 @@ import (
 	"hash"
 	"io/ioutil"
 
-	"github.com/kiriminaja/kaj-golang-pkg/logger"
-
 	"github.com/xdg/scram"
 )
 
@@ -40,23 +38,25 @@ func (x *XDGSCRAMClient) Done() bool {
 	return x.ClientConversation.Done()
 }
 
-func createTlsConfig(c TLS) (t *tls.Config) {
-	t = &tls.Config{
+func createTlsConfig(c TLS) (*tls.Config, error) {
+	t := &tls.Config{
 		InsecureSkipVerify: c.SkipVerify,
 	}
 	if c.CertFile != "" && c.KeyFile != "" && c.CaFile != "" {
 		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
 		if err != nil {
-			logger.Fatal(err)
+			return nil, &FieldError{Field: "tls.cert_file", Value: c.CertFile, Message: "load key pair got: " + err.Error()}
 		}
 
 		caCert, err := ioutil.ReadFile(c.CaFile)
 		if err != nil {
-			logger.Fatal(err)
+			return nil, &FieldError{Field: "tls.ca_file", Value: c.CaFile, Message: "read got: " + err.Error()}
 		}
 
 		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, &FieldError{Field: "tls.ca_file", Value: c.CaFile, Message: "has no PEM certificate"}
+		}
 
 		t = &tls.Config{
 			Certificates:       []tls.Certificate{cert},
@@ -64,5 +64,5 @@ func createTlsConfig(c TLS) (t *tls.Config) {
 			InsecureSkipVerify: c.SkipVerify,
 		}
 	}
-	return t
+	return t, nil
 }
@@ -0,0 +1,24 @@
+// Package logger
+package logger
+
+import "context"
+
+type contextKey string
+
+const (
+	// RequestIDKey holds the request id field
+	RequestIDKey = "request_id"
+
+	requestIDContextKey contextKey = RequestIDKey
+)
+
+// ContextWithRequestID return ctx carrying the request id, it is logged by InfoWithContext
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestIDFromContext return the request id carried by ctx, empty when none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
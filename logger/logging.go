@@ -113,9 +113,13 @@ func AccessLog(arg interface{}, fl ...Field) {
 }
 
 func InfoWithContext(ctx context.Context, arg interface{}, fl ...Field) {
-	logrus.WithFields(extractContext(ctx.Value("access"), map[string]interface{}{
+	logField := map[string]interface{}{
 		"event": extract(fl...),
-	})).WithContext(ctx).Info(arg)
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		logField[RequestIDKey] = id
+	}
+	logrus.WithFields(extractContext(ctx.Value("access"), logField)).WithContext(ctx).Info(arg)
 }
 
 func extractContext(i interface{}, logField map[string]interface{}) map[string]interface{} {
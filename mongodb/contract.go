@@ -23,6 +23,23 @@ type Adapter interface {
 	Pipeline() mongo.Pipeline
 }
 
+// Transactor run functions in a transaction, the client of NewMongoClient
+// implements it. It is kept out of Adapter so existing implementations of
+// Adapter do not break.
+type Transactor interface {
+	// WithTransaction run fn in a transaction of a new session, the writes
+	// made with the context of fn are committed together. fn is retried on
+	// transient transaction errors.
+	WithTransaction(ctx context.Context, fn func(ctx mongo.SessionContext) error) error
+}
+
+// Pinger report the outcome of a ping, the client of NewMongoClient
+// implements it. Adapter.Ping ignores the error of the server.
+type Pinger interface {
+	// PingContext check the connection to the server
+	PingContext(ctx context.Context) error
+}
+
 type Config struct {
 	Username string
 	Password string
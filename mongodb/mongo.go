@@ -80,6 +80,23 @@ func (m *mongoDB) Ping(ctx context.Context) {
 	m.client.Ping(ctx, nil)
 }
 
+func (m *mongoDB) PingContext(ctx context.Context) error {
+	return m.client.Ping(ctx, nil)
+}
+
+func (m *mongoDB) WithTransaction(ctx context.Context, fn func(ctx mongo.SessionContext) error) error {
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
 func (m *mongoDB) Upsert(ctx context.Context, collection string, id uint64, data interface{}) (*mongo.UpdateResult, error) {
 	filter := bson.M{"_id": id}
 	update := bson.M{"$set": data}
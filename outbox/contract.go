@@ -0,0 +1,87 @@
+package outbox
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusPublished = "published"
+	// StatusFailed event given up after Config.MaxAttempts, it is no longer
+	// published
+	StatusFailed = "failed"
+)
+
+// Relay publish the outbox events to kafka
+type Relay interface {
+	// Run publish the pending events until ctx is cancelled. Events are
+	// published at least once and in commit order per aggregate key, the
+	// next events of a key are published once a failing one is given up.
+	Run(ctx context.Context) error
+}
+
+// Store persist the outbox events
+type Store interface {
+	// Add insert events and assign their Sequence, call it with the context
+	// of the transaction of the domain change so both are committed
+	// together, see Transaction.
+	Add(ctx context.Context, events ...*Event) error
+	// Pending return up to limit pending events with a Sequence above after
+	// in Sequence order, including the ones waiting for their RetryAt.
+	Pending(ctx context.Context, after int64, limit int) ([]*Event, error)
+	MarkPublished(ctx context.Context, id string, at time.Time) error
+	// MarkFailed record a failed publishing, the event stays pending and is
+	// retried from retryAt.
+	MarkFailed(ctx context.Context, id string, cause string, retryAt time.Time) error
+	// GiveUp record a failed publishing and move the event to StatusFailed
+	GiveUp(ctx context.Context, id string, cause string) error
+	// Lock acquire or renew the relay lease of owner until now + lease,
+	// false when another relay holds it.
+	Lock(ctx context.Context, owner string, now time.Time, lease time.Duration) (bool, error)
+	// Watch return a channel signalled when events are added, it is closed
+	// once the watch stops.
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+type Config struct {
+	// UseChangeStream wake the relay on new events with Store.Watch, polling
+	// is kept as fallback. Mongo change streams require a replica set.
+	UseChangeStream bool
+	// PollIntervalSecond how often Run look for pending events, defaults to 1.
+	PollIntervalSecond int
+	// BatchSize maximum events published per poll, defaults to 100.
+	BatchSize int
+	// LeaseSecond how long a relay keep publishing without renewing its
+	// lease, a single relay publish at a time. The lease is renewed during
+	// a batch once a third of it is elapsed. Defaults to 30.
+	LeaseSecond int
+	// MaxAttempts publishing attempts of an event before it is given up,
+	// defaults to 10.
+	MaxAttempts int
+	// BackoffSecond wait before retrying a failed event, doubled on each
+	// attempt up to 5 minutes. Defaults to 1.
+	BackoffSecond int
+}
+
+// Event message waiting in the outbox to be published
+type Event struct {
+	ID    string `json:"id" bson:"_id"`
+	Topic string `json:"topic" bson:"topic"`
+	// Key aggregate key, used as kafka message key. Events of a key are
+	// published in commit order.
+	Key     string            `json:"key" bson:"key"`
+	Payload []byte            `json:"payload" bson:"payload"`
+	Message string            `json:"message,omitempty" bson:"message,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
+	Status  string            `json:"status" bson:"status"`
+	// Sequence assigned by Store.Add in commit order, the relay publish in
+	// this order.
+	Sequence int64 `json:"sequence" bson:"sequence"`
+	Attempts int   `json:"attempts" bson:"attempts"`
+	// RetryAt the event is not published before, set by a failed attempt
+	RetryAt     *time.Time `json:"retry_at,omitempty" bson:"retry_at,omitempty"`
+	Error       string     `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	PublishedAt *time.Time `json:"published_at,omitempty" bson:"published_at,omitempty"`
+}
@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewEvent return a pending event of payload for topic, the request id of
+// ctx is kept in the message headers.
+func NewEvent(ctx context.Context, topic, key string, payload interface{}) (*Event, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal outbox payload got: %w", err)
+	}
+	e := &Event{
+		ID:        primitive.NewObjectID().Hex(),
+		Topic:     topic,
+		Key:       key,
+		Payload:   body,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+	}
+	if id := logger.RequestIDFromContext(ctx); id != "" {
+		e.Headers = map[string]string{kafka.HeaderRequestID: id}
+	}
+	return e, nil
+}
+
+// message return the kafka message of e
+func (e *Event) message() *kafka.MessageContext {
+	return &kafka.MessageContext{
+		Topic:   e.Topic,
+		Key:     []byte(e.Key),
+		Headers: e.Headers,
+		LogId:   e.ID,
+		Value: &kafka.BodyStateful{
+			Body:    json.RawMessage(e.Payload),
+			Message: e.Message,
+		},
+	}
+}
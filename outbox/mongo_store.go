@@ -0,0 +1,161 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+	"github.com/kiriminaja/kaj-golang-pkg/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoStore struct {
+	adapter    mongodb.Adapter
+	collection string
+}
+
+var errNoTransaction = errors.New("outbox transaction requires an adapter implementing mongodb.Transactor")
+
+// NewMongoStore return a Store of the events in collection, the relay lease
+// is kept in the "<collection>_lock" collection and the sequence of the
+// events in the "<collection>_seq" collection. Index the events on
+// {status: 1, sequence: 1}.
+func NewMongoStore(adapter mongodb.Adapter, collection string) Store {
+	return &mongoStore{adapter: adapter, collection: collection}
+}
+
+// Transaction run fn in a transaction of adapter, the domain change and the
+// events added with the context of fn are committed together. adapter must
+// implement mongodb.Transactor, as the client of mongodb.NewMongoClient does.
+//
+//	err := outbox.Transaction(ctx, adapter, func(ctx mongo.SessionContext) error {
+//		if _, err := orders.InsertOne(ctx, order); err != nil {
+//			return err
+//		}
+//		return store.Add(ctx, event)
+//	})
+func Transaction(ctx context.Context, adapter mongodb.Adapter, fn func(ctx mongo.SessionContext) error) error {
+	tx, ok := adapter.(mongodb.Transactor)
+	if !ok {
+		return errNoTransaction
+	}
+	return tx.WithTransaction(ctx, fn)
+}
+
+func (s *mongoStore) coll() *mongo.Collection {
+	return s.adapter.SetCollection(s.collection, s.adapter.OptionCollection())
+}
+
+// Add take the sequence of events from a counter updated in the transaction
+// of ctx. A concurrent transaction adding events conflict on the counter and
+// is retried once this one is committed, so the sequence follow the commit
+// order, object ids are taken before the transaction and do not.
+func (s *mongoStore) Add(ctx context.Context, events ...*Event) error {
+	counter := s.adapter.SetCollection(s.collection+"_seq", s.adapter.OptionCollection())
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var seq struct {
+		Value int64 `bson:"value"`
+	}
+	err := counter.FindOneAndUpdate(ctx, bson.M{"_id": s.collection},
+		bson.M{"$inc": bson.M{"value": int64(len(events))}}, opts).Decode(&seq)
+	if err != nil {
+		return err
+	}
+
+	docs := make([]interface{}, 0, len(events))
+	first := seq.Value - int64(len(events)) + 1
+	for i, e := range events {
+		cp := *e
+		cp.Sequence = first + int64(i)
+		docs = append(docs, &cp)
+	}
+	_, err = s.coll().InsertMany(ctx, docs)
+	return err
+}
+
+func (s *mongoStore) Pending(ctx context.Context, after int64, limit int) ([]*Event, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sequence", Value: 1}}).
+		SetLimit(int64(limit))
+	filter := bson.M{"status": StatusPending, "sequence": bson.M{"$gt": after}}
+	cursor, err := s.coll().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Event, 0)
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *mongoStore) MarkPublished(ctx context.Context, id string, at time.Time) error {
+	_, err := s.coll().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":       StatusPublished,
+		"published_at": at,
+	}})
+	return err
+}
+
+func (s *mongoStore) MarkFailed(ctx context.Context, id string, cause string, retryAt time.Time) error {
+	_, err := s.coll().UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"error": cause, "retry_at": retryAt},
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+func (s *mongoStore) GiveUp(ctx context.Context, id string, cause string) error {
+	_, err := s.coll().UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"error": cause, "status": StatusFailed},
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+func (s *mongoStore) Lock(ctx context.Context, owner string, now time.Time, lease time.Duration) (bool, error) {
+	filter := bson.M{
+		"_id": s.collection,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expire_at": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expire_at": now.Add(lease)}}
+	lock := s.adapter.SetCollection(s.collection+"_lock", s.adapter.OptionCollection())
+	_, err := lock.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	// the lock exist and is held by another relay, the upsert conflict with it
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *mongoStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert"}}},
+	}
+	stream, err := s.adapter.Watch(ctx, s.collection, s.adapter.OptionCollection(), pipeline, s.adapter.OptionChangeStream())
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		defer stream.Close(context.Background())
+		for stream.Next(ctx) {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			logger.Error(logger.SetMessageFormat("[outbox] watch %s got: %s", s.collection, err.Error()))
+		}
+	}()
+	return ch, nil
+}
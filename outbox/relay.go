@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+	"github.com/kiriminaja/kaj-golang-pkg/util"
+)
+
+const (
+	defaultPollIntervalSecond = 1
+	defaultBatchSize          = 100
+	defaultLeaseSecond        = 30
+	defaultMaxAttempts        = 10
+	defaultBackoffSecond      = 1
+	maxBackoff                = 5 * time.Minute
+)
+
+type relay struct {
+	cfg      *Config
+	store    Store
+	producer kafka.Producer
+	owner    string
+	now      func() time.Time
+}
+
+// NewRelay return relay publishing the events of store through producer
+func NewRelay(cfg *Config, store Store, producer kafka.Producer) Relay {
+	if cfg.PollIntervalSecond < 1 {
+		cfg.PollIntervalSecond = defaultPollIntervalSecond
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.LeaseSecond < 1 {
+		cfg.LeaseSecond = defaultLeaseSecond
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.BackoffSecond < 1 {
+		cfg.BackoffSecond = defaultBackoffSecond
+	}
+	return &relay{
+		cfg:      cfg,
+		store:    store,
+		producer: producer,
+		owner:    util.GenerateUUID(),
+		now:      time.Now,
+	}
+}
+
+func (r *relay) Run(ctx context.Context) error {
+	var notify <-chan struct{}
+	if r.cfg.UseChangeStream {
+		ch, err := r.store.Watch(ctx)
+		if err != nil {
+			logger.Warn(logger.SetMessageFormat("[outbox] watch events got: %s, polling every %ds", err.Error(), r.cfg.PollIntervalSecond))
+		}
+		notify = ch
+	}
+
+	ticker := time.NewTicker(time.Duration(r.cfg.PollIntervalSecond) * time.Second)
+	defer ticker.Stop()
+	for {
+		more, err := r.process(ctx)
+		if err != nil {
+			logger.Error(logger.SetMessageFormat("[outbox] relay events got: %s", err.Error()))
+		}
+		if more && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case _, ok := <-notify:
+			if !ok {
+				logger.Warn(logger.SetMessageFormat("[outbox] watch stopped, polling every %ds", r.cfg.PollIntervalSecond))
+				notify = nil
+			}
+		}
+	}
+}
+
+// process publish a batch of pending events when the relay holds the lease,
+// true when a full batch was published and more events may be pending. The
+// pending events are paged past the blocked keys, so a key failing with many
+// events does not hold back the other keys.
+func (r *relay) process(ctx context.Context) (bool, error) {
+	lease := time.Duration(r.cfg.LeaseSecond) * time.Second
+	ok, err := r.store.Lock(ctx, r.owner, r.now(), lease)
+	if err != nil || !ok {
+		return false, err
+	}
+	renewed := r.now()
+
+	// a failed or waiting key is blocked for the rest of the batch so its
+	// next events are not published before it
+	blocked := map[string]bool{}
+	published := 0
+	var after int64
+	for published < r.cfg.BatchSize && ctx.Err() == nil {
+		events, err := r.store.Pending(ctx, after, r.cfg.BatchSize)
+		if err != nil {
+			return false, err
+		}
+		for _, e := range events {
+			if published == r.cfg.BatchSize {
+				break
+			}
+			after = e.Sequence
+
+			// renew the lease well before it expires, a relay taking over
+			// an expired lease would publish the rest of the batch again
+			if r.now().Sub(renewed) > lease/3 {
+				ok, err := r.store.Lock(ctx, r.owner, r.now(), lease)
+				if err != nil || !ok {
+					return false, err
+				}
+				renewed = r.now()
+			}
+
+			if blocked[e.Key] {
+				continue
+			}
+			if e.RetryAt != nil && r.now().Before(*e.RetryAt) {
+				blocked[e.Key] = true
+				continue
+			}
+			if err := r.producer.Publish(ctx, e.message()); err != nil {
+				blocked[e.Key] = true
+				if err := r.fail(ctx, e, err); err != nil {
+					return false, err
+				}
+				continue
+			}
+			// a crash before the mark publish the event again, consumers
+			// must be idempotent
+			if err := r.store.MarkPublished(ctx, e.ID, r.now()); err != nil {
+				return false, err
+			}
+			published++
+		}
+		if len(events) < r.cfg.BatchSize {
+			break
+		}
+	}
+	return published == r.cfg.BatchSize, nil
+}
+
+// fail record the failed publishing of e, it is retried after a backoff
+// and given up after Config.MaxAttempts.
+func (r *relay) fail(ctx context.Context, e *Event, cause error) error {
+	attempts := e.Attempts + 1
+	if attempts >= r.cfg.MaxAttempts {
+		logger.Error(logger.SetMessageFormat("[outbox] give up event %s to %s after %d attempts got: %s", e.ID, e.Topic, attempts, cause.Error()))
+		return r.store.GiveUp(ctx, e.ID, cause.Error())
+	}
+	logger.Warn(logger.SetMessageFormat("[outbox] publish event %s to %s attempt %d got: %s", e.ID, e.Topic, attempts, cause.Error()))
+	return r.store.MarkFailed(ctx, e.ID, cause.Error(), r.now().Add(r.backoff(attempts)))
+}
+
+// backoff return the wait after the attempts of an event, doubled on each
+// attempt up to maxBackoff.
+func (r *relay) backoff(attempts int) time.Duration {
+	d := time.Duration(r.cfg.BackoffSecond) * time.Second
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
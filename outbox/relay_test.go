@@ -0,0 +1,209 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/kafka"
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProducer record the published messages, it fails the keys of fail once
+// and the keys of broken every time.
+type fakeProducer struct {
+	published []string
+	fail      map[string]bool
+	broken    map[string]bool
+	onPublish func()
+}
+
+func (p *fakeProducer) Publish(_ context.Context, msg *kafka.MessageContext) error {
+	if p.onPublish != nil {
+		p.onPublish()
+	}
+	if p.broken[string(msg.Key)] {
+		return errors.New("message too large")
+	}
+	if p.fail[string(msg.Key)] {
+		delete(p.fail, string(msg.Key))
+		return errors.New("broker down")
+	}
+	p.published = append(p.published, msg.LogId.(string))
+	return nil
+}
+
+func (p *fakeProducer) PublishBatch(ctx context.Context, msgs []*kafka.MessageContext) error {
+	for _, msg := range msgs {
+		if err := p.Publish(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *fakeProducer) Close() error {
+	return nil
+}
+
+func TestRelayOrderPerKey(t *testing.T) {
+	ctx := logger.ContextWithRequestID(context.Background(), "req-1")
+	store := NewMemoryStore()
+	events := make([]*Event, 0)
+	for _, key := range []string{"order-1", "order-2", "order-1"} {
+		e, err := NewEvent(ctx, "orders", key, map[string]string{"key": key})
+		assert.NoError(t, err)
+		events = append(events, e)
+	}
+	assert.NoError(t, store.Add(ctx, events...))
+	assert.Equal(t, "req-1", events[0].Headers[kafka.HeaderRequestID])
+
+	producer := &fakeProducer{fail: map[string]bool{"order-1": true}}
+	r := NewRelay(&Config{}, store, producer).(*relay)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	_, err := r.process(ctx)
+	assert.NoError(t, err)
+	// order-1 is blocked after its first event failed
+	assert.Equal(t, []string{events[1].ID}, producer.published)
+	pending, _ := store.Pending(ctx, 0, 10)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, 1, pending[0].Attempts)
+
+	// still blocked until its backoff is elapsed
+	_, err = r.process(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, producer.published, 1)
+
+	now = now.Add(time.Second)
+	_, err = r.process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{events[1].ID, events[0].ID, events[2].ID}, producer.published)
+	pending, _ = store.Pending(ctx, 0, 10)
+	assert.Empty(t, pending)
+}
+
+func TestRelaySingleLeader(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	e, _ := NewEvent(ctx, "orders", "order-1", 1)
+	assert.NoError(t, store.Add(ctx, e))
+
+	first := &fakeProducer{}
+	second := &fakeProducer{}
+	_, err := NewRelay(&Config{}, store, first).(*relay).process(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(ctx, &Event{ID: "next", Key: "order-1", Status: StatusPending}))
+	_, err = NewRelay(&Config{}, store, second).(*relay).process(ctx)
+	assert.NoError(t, err)
+
+	assert.Len(t, first.published, 1)
+	assert.Empty(t, second.published)
+}
+
+func TestRelayGiveUp(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	first, _ := NewEvent(ctx, "orders", "order-1", 1)
+	next, _ := NewEvent(ctx, "orders", "order-1", 2)
+	assert.NoError(t, store.Add(ctx, first, next))
+
+	producer := &fakeProducer{broken: map[string]bool{"order-1": true}}
+	r := NewRelay(&Config{MaxAttempts: 3}, store, producer).(*relay)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	var backoffs []time.Duration
+	for i := 0; i < 3; i++ {
+		_, err := r.process(ctx)
+		assert.NoError(t, err)
+		pending, _ := store.Pending(ctx, 0, 10)
+		if pending[0].RetryAt != nil {
+			backoffs = append(backoffs, pending[0].RetryAt.Sub(now))
+			now = *pending[0].RetryAt
+		}
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, backoffs)
+
+	// the failed event no longer block the next events of its key
+	pending, _ := store.Pending(ctx, 0, 10)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, next.ID, pending[0].ID)
+	}
+	delete(producer.broken, "order-1")
+	_, err := r.process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{next.ID}, producer.published)
+}
+
+func TestRelayRenewLease(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for _, key := range []string{"order-1", "order-2", "order-3"} {
+		e, _ := NewEvent(ctx, "orders", key, key)
+		assert.NoError(t, store.Add(ctx, e))
+	}
+
+	// each publish take 20s of a 30s lease
+	now := time.Now()
+	producer := &fakeProducer{onPublish: func() { now = now.Add(20 * time.Second) }}
+	r := NewRelay(&Config{}, store, producer).(*relay)
+	r.now = func() time.Time { return now }
+	other := NewRelay(&Config{}, store, &fakeProducer{}).(*relay)
+	other.now = r.now
+
+	_, err := r.process(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, producer.published, 3)
+
+	ok, err := store.Lock(ctx, other.owner, now, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok, "the lease was renewed during the batch")
+}
+
+func TestRelayPagePastBlockedKey(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for i := 0; i < 5; i++ {
+		e, _ := NewEvent(ctx, "orders", "order-1", i)
+		assert.NoError(t, store.Add(ctx, e))
+	}
+	other, _ := NewEvent(ctx, "orders", "order-2", 1)
+	assert.NoError(t, store.Add(ctx, other))
+
+	// order-1 fill more than a batch and keep failing
+	producer := &fakeProducer{broken: map[string]bool{"order-1": true}}
+	r := NewRelay(&Config{BatchSize: 2}, store, producer).(*relay)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := r.process(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{other.ID}, producer.published)
+	}
+	pending, _ := store.Pending(ctx, 0, 10)
+	assert.Len(t, pending, 5)
+	assert.Equal(t, 1, pending[0].Attempts)
+}
+
+func TestMemoryStoreWatch(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := store.Watch(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Add(ctx, &Event{ID: "a", Status: StatusPending}))
+	_, ok := <-ch
+	assert.True(t, ok)
+
+	cancel()
+	for range ch {
+	}
+	store.mu.Lock()
+	assert.Empty(t, store.watchers)
+	store.mu.Unlock()
+}
@@ -0,0 +1,139 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrEventNotFound = errors.New("outbox event not found")
+
+type memoryStore struct {
+	mu       sync.Mutex
+	events   []*Event
+	sequence int64
+	owner    string
+	expireAt time.Time
+	watchers []chan struct{}
+}
+
+// NewMemoryStore return a Store kept in memory, it is not transactional and
+// is meant for tests.
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+func (s *memoryStore) Add(_ context.Context, events ...*Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range events {
+		cp := *e
+		s.sequence++
+		cp.Sequence = s.sequence
+		s.events = append(s.events, &cp)
+	}
+	for _, w := range s.watchers {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Pending(_ context.Context, after int64, limit int) ([]*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]*Event, 0)
+	for _, e := range s.events {
+		if len(result) >= limit {
+			break
+		}
+		if e.Status == StatusPending && e.Sequence > after {
+			cp := *e
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func (s *memoryStore) find(id string) (*Event, error) {
+	for _, e := range s.events {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return nil, ErrEventNotFound
+}
+
+func (s *memoryStore) MarkPublished(_ context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.find(id)
+	if err != nil {
+		return err
+	}
+	e.Status = StatusPublished
+	e.PublishedAt = &at
+	return nil
+}
+
+func (s *memoryStore) MarkFailed(_ context.Context, id string, cause string, retryAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.find(id)
+	if err != nil {
+		return err
+	}
+	e.Attempts++
+	e.Error = cause
+	e.RetryAt = &retryAt
+	return nil
+}
+
+func (s *memoryStore) GiveUp(_ context.Context, id string, cause string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.find(id)
+	if err != nil {
+		return err
+	}
+	e.Attempts++
+	e.Error = cause
+	e.Status = StatusFailed
+	return nil
+}
+
+func (s *memoryStore) Lock(_ context.Context, owner string, now time.Time, lease time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner != owner && now.Before(s.expireAt) {
+		return false, nil
+	}
+	s.owner = owner
+	s.expireAt = now.Add(lease)
+	return true, nil
+}
+
+// Watch signal the events added until ctx is done, then the channel is
+// closed and removed from the watchers.
+func (s *memoryStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	s.watchers = append(s.watchers, ch)
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, w := range s.watchers {
+			if w == ch {
+				s.watchers = append(s.watchers[:i], s.watchers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
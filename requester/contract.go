@@ -9,6 +9,18 @@ import (
 type Config struct {
 	Timeout int
 	Debug   bool
+	// RetryCount number of retries of a request failing before a response
+	// is received. Only idempotent methods (GET, HEAD, OPTIONS, PUT, DELETE)
+	// are retried unless RetryNonIdempotent is set.
+	RetryCount int
+	// RetryNonIdempotent retry POST and PATCH requests too, including
+	// PostXML, SOAP and GraphQL. Only set it when the servers deduplicate
+	// them, e.g. with an idempotency key, a request may have been processed
+	// before its response was lost.
+	RetryNonIdempotent bool
+	// Middlewares wrap every request sent by the requester, including the
+	// ones built from RAW. The first middleware is the outermost one.
+	Middlewares []Middleware
 }
 
 type RequesterContract interface {
@@ -20,3 +32,14 @@ type RequesterContract interface {
 	Upload(url string, body, headers map[string]string,
 		param, filename string, reader io.Reader, result interface{}) (*req.Response, error)
 }
+
+// Requester RequesterContract with middlewares, XML and GraphQL requests,
+// NewRequester implements it. It is kept apart so existing implementations
+// of RequesterContract do not break.
+type Requester interface {
+	RequesterContract
+	Use(middlewares ...Middleware)
+	PostXML(url string, body interface{}, headers map[string]string, result interface{}) (*req.Response, error)
+	SOAP(url, action string, body interface{}, headers map[string]string, result interface{}) (*req.Response, error)
+	GraphQL(url, query string, variables map[string]interface{}, headers map[string]string, result interface{}) (*req.Response, error)
+}
@@ -0,0 +1,91 @@
+package requester
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/imroc/req/v3"
+)
+
+// GraphQLError single error of a GraphQL response
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Locations  []GraphQLLocation      `json:"locations,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (e GraphQLError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+	path := make([]string, 0, len(e.Path))
+	for _, p := range e.Path {
+		path = append(path, fmt.Sprint(p))
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(path, "."), e.Message)
+}
+
+// GraphQLErrors errors array of a GraphQL response
+type GraphQLErrors []GraphQLError
+
+func (e GraphQLErrors) Error() string {
+	msg := make([]string, 0, len(e))
+	for _, v := range e {
+		msg = append(msg, v.Error())
+	}
+	return "graphql: " + strings.Join(msg, "; ")
+}
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors GraphQLErrors   `json:"errors"`
+}
+
+// GraphQL send query and decode the data field into result, the errors
+// field is returned as GraphQLErrors. Partial data is still decoded.
+func (r *reqsPkg) GraphQL(url, query string, variables map[string]interface{}, headers map[string]string, result interface{}) (*req.Response, error) {
+	body := &graphQLRequest{Query: query, Variables: variables}
+	client := r.client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeaders(headers).
+		SetBody(body)
+
+	response, err := r.send("POST", "GraphQL", url, client,
+		logger.Any("headers", headers),
+		logger.Any("body", body),
+	)
+	if err != nil {
+		return response, err
+	}
+
+	out := &graphQLResponse{}
+	if err := json.Unmarshal(response.Bytes(), out); err != nil {
+		if response.IsErrorState() {
+			return response, nil
+		}
+		return response, fmt.Errorf("unmarshal graphql response got: %w", err)
+	}
+	if result != nil && len(out.Data) > 0 && string(out.Data) != "null" {
+		if err := json.Unmarshal(out.Data, result); err != nil {
+			return response, fmt.Errorf("unmarshal graphql data got: %w", err)
+		}
+	}
+	if len(out.Errors) > 0 {
+		return response, out.Errors
+	}
+	return response, nil
+}
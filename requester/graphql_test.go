@@ -0,0 +1,29 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"order":{"id":"1"}},"errors":[{"message":"not allowed","path":["order","items"]}]}`))
+	}))
+	defer srv.Close()
+
+	r := NewRequester(&Config{Timeout: 5})
+	result := struct {
+		Order struct {
+			ID string `json:"id"`
+		} `json:"order"`
+	}{}
+	_, err := r.GraphQL(srv.URL, `query { order(id: 1) { id items } }`, nil, nil, &result)
+	assert.Equal(t, "1", result.Order.ID)
+	errs, ok := err.(GraphQLErrors)
+	assert.True(t, ok)
+	assert.Equal(t, "graphql: order.items: not allowed", errs.Error())
+}
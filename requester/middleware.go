@@ -0,0 +1,39 @@
+package requester
+
+import (
+	"github.com/imroc/req/v3"
+)
+
+// RoundTripper sends a single request and returns its response.
+type RoundTripper = req.RoundTripper
+
+// RoundTripFunc adapts a plain function to RoundTripper.
+type RoundTripFunc = req.RoundTripFunc
+
+// Middleware wraps the next RoundTripper of the chain, it may modify the
+// request, short-circuit with its own response or inspect the result.
+type Middleware func(next RoundTripper) RoundTripper
+
+// chain builds the round tripper of the registered middlewares, the first
+// registered middleware is the outermost one.
+func chain(next RoundTripper, middlewares []Middleware) RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		next = middlewares[i](next)
+	}
+	return next
+}
+
+// HeaderMiddleware set the given headers on every request, headers already
+// set on the request are kept as is.
+func HeaderMiddleware(headers map[string]string) Middleware {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripFunc(func(r *req.Request) (*req.Response, error) {
+			for k, v := range headers {
+				if r.Headers.Get(k) == "" {
+					r.Headers.Set(k, v)
+				}
+			}
+			return next.RoundTrip(r)
+		})
+	}
+}
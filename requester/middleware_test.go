@@ -0,0 +1,63 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imroc/req/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"trace":"` + r.Header.Get("X-Trace") + `","sign":"` + r.Header.Get("X-Sign") + `"}`))
+	}))
+	defer srv.Close()
+
+	calls := make([]string, 0)
+	record := func(name string) Middleware {
+		return func(next RoundTripper) RoundTripper {
+			return RoundTripFunc(func(r *req.Request) (*req.Response, error) {
+				calls = append(calls, name)
+				return next.RoundTrip(r)
+			})
+		}
+	}
+
+	r := NewRequester(&Config{
+		Timeout:     5,
+		Middlewares: []Middleware{record("first"), HeaderMiddleware(map[string]string{"X-Trace": "abc"})},
+	})
+	r.Use(record("second"), func(next RoundTripper) RoundTripper {
+		return RoundTripFunc(func(r *req.Request) (*req.Response, error) {
+			r.Headers.Set("X-Sign", r.Headers.Get("X-Trace")+"-signed")
+			return next.RoundTrip(r)
+		})
+	})
+
+	result := map[string]string{}
+	_, err := r.GET(srv.URL, nil, nil, &result)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, calls)
+	assert.Equal(t, "abc", result["trace"])
+	assert.Equal(t, "abc-signed", result["sign"])
+
+	calls = calls[:0]
+	_, err = r.RAW().Get(srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, calls)
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	r := NewRequester(&Config{Timeout: 5})
+	r.Use(func(next RoundTripper) RoundTripper {
+		return RoundTripFunc(func(r *req.Request) (*req.Response, error) {
+			return nil, assert.AnError
+		})
+	})
+
+	_, err := r.GET("http://127.0.0.1:1", nil, nil, nil)
+	assert.ErrorIs(t, err, assert.AnError)
+}
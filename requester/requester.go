@@ -2,7 +2,9 @@ package requester
 
 import (
 	"io"
+	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/kiriminaja/kaj-golang-pkg/logger"
@@ -11,20 +13,42 @@ import (
 )
 
 type reqsPkg struct {
-	logField []logger.Field
-	client   *req.Client
-	cfg      *Config
+	logField    []logger.Field
+	client      *req.Client
+	cfg         *Config
+	mu          sync.RWMutex
+	middlewares []Middleware
 }
 
-func NewRequester(cfg *Config) RequesterContract {
-
-	return &reqsPkg{
-		client: buildClient(cfg),
-		cfg:    cfg,
+func NewRequester(cfg *Config) Requester {
+	r := &reqsPkg{
+		client:      buildClient(cfg),
+		cfg:         cfg,
+		middlewares: append([]Middleware{}, cfg.Middlewares...),
 		logField: []logger.Field{
 			logger.EventName("requester:log"),
 		},
 	}
+	r.client.WrapRoundTrip(r.wrap)
+	return r
+}
+
+// Use append middlewares to the chain, they run after the ones already registered.
+func (r *reqsPkg) Use(middlewares ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// wrap resolve the middleware chain on each request, so middlewares added
+// by Use apply to a client that is already built.
+func (r *reqsPkg) wrap(next RoundTripper) RoundTripper {
+	return RoundTripFunc(func(request *req.Request) (*req.Response, error) {
+		r.mu.RLock()
+		middlewares := r.middlewares
+		r.mu.RUnlock()
+		return chain(next, middlewares).RoundTrip(request)
+	})
 }
 
 func buildClient(cfg *Config) *req.Client {
@@ -38,10 +62,28 @@ func buildClient(cfg *Config) *req.Client {
 		})
 		client.DevMode()
 	}
+	if cfg.RetryCount > 0 {
+		client.SetCommonRetryCount(cfg.RetryCount).
+			SetCommonRetryCondition(func(resp *req.Response, err error) bool {
+				return err != nil && (cfg.RetryNonIdempotent || idempotent(resp))
+			})
+	}
 	client.SetUserAgent("Go-http-client/1.1")
 	return client
 }
 
+// idempotent report whether the request of resp can be sent again safely
+func idempotent(resp *req.Response) bool {
+	if resp == nil || resp.Request == nil {
+		return false
+	}
+	switch resp.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
 func (r *reqsPkg) RAW() *req.Request {
 	return r.client.R().
 		SetHeader("Content-Type", "application/json")
@@ -51,138 +93,86 @@ func (r *reqsPkg) GET(url string, params map[string]string, headers map[string]s
 	client := r.client.R().
 		SetHeaders(headers).
 		SetQueryParams(params).
-		SetHeader("Content-Type", "application/json")
-
-	r.logField = append(r.logField, logger.Any("url", url))
-	r.logField = append(r.logField, logger.Any("headers", headers))
-	r.logField = append(r.logField, logger.Any("params", params))
-	r.logField = append(r.logField, logger.Any("method", "GET"))
-
-	if r.cfg.Debug {
-		client.EnableTrace()
-	}
-	response, err := client.SetSuccessResult(result).Get(url)
-	if err != nil {
-		logger.Error(logger.SetMessageFormat("Error GET request"), r.logField...)
-		return nil, err
-	}
+		SetHeader("Content-Type", "application/json").
+		SetSuccessResult(result)
 
-	if response.IsErrorState() {
-		logger.Error(logger.SetMessageFormat("Error State GET request"), r.logField...)
-		return response, nil
-	}
-	r.logField = append(r.logField, logger.Any("duration", response.TotalTime()))
-	logger.Info(logger.SetMessageFormat("Success GET request"), r.logField...)
-	return response, nil
+	return r.send("GET", "GET", url, client,
+		logger.Any("headers", headers),
+		logger.Any("params", params),
+	)
 }
 
 func (r *reqsPkg) POST(url string, body interface{}, headers map[string]string, result interface{}) (*req.Response, error) {
 	client := r.client.R().
 		SetHeader("Content-Type", "application/json").
-		SetHeaders(headers).SetBody(body)
-
-	r.logField = append(r.logField, logger.Any("url", url))
-	r.logField = append(r.logField, logger.Any("headers", headers))
-	r.logField = append(r.logField, logger.Any("body", body))
-	r.logField = append(r.logField, logger.Any("method", "POST"))
-
-	if r.cfg.Debug {
-		client.EnableTrace()
-	}
-	response, err := client.SetSuccessResult(result).Post(url)
-	if err != nil {
-		logger.Error(logger.SetMessageFormat("Error POST request"), r.logField...)
-		return nil, err
-	}
+		SetHeaders(headers).SetBody(body).
+		SetSuccessResult(result)
 
-	if response.IsErrorState() {
-		logger.Error(logger.SetMessageFormat("Error State POST request"), r.logField...)
-		return response, nil
-	}
-	r.logField = append(r.logField, logger.Any("duration", response.TotalTime()))
-	logger.Info(logger.SetMessageFormat("Success POST request"), r.logField...)
-	return response, nil
+	return r.send("POST", "POST", url, client,
+		logger.Any("headers", headers),
+		logger.Any("body", body),
+	)
 }
 
 func (r *reqsPkg) PUT(url string, body interface{}, headers map[string]string, result interface{}) (*req.Response, error) {
 	client := r.client.R().
 		SetHeader("Content-Type", "application/json").
-		SetHeaders(headers).SetBody(body)
-
-	r.logField = append(r.logField, logger.Any("url", url))
-	r.logField = append(r.logField, logger.Any("headers", headers))
-	r.logField = append(r.logField, logger.Any("body", body))
-	r.logField = append(r.logField, logger.Any("method", "PUT"))
-
-	if r.cfg.Debug {
-		client.EnableTrace()
-	}
-	response, err := client.SetSuccessResult(result).Put(url)
-	if err != nil {
-		logger.Error(logger.SetMessageFormat("Error PUT request"), r.logField...)
-		return nil, err
-	}
+		SetHeaders(headers).SetBody(body).
+		SetSuccessResult(result)
 
-	if response.IsErrorState() {
-		logger.Error(logger.SetMessageFormat("Error State PUT request"), r.logField...)
-		return response, nil
-	}
-	r.logField = append(r.logField, logger.Any("duration", response.TotalTime()))
-	logger.Info(logger.SetMessageFormat("Success PUT request"), r.logField...)
-	return response, nil
+	return r.send("PUT", "PUT", url, client,
+		logger.Any("headers", headers),
+		logger.Any("body", body),
+	)
 }
 
 func (r *reqsPkg) DELETE(url string, params map[string]string, headers map[string]string, result interface{}) (*req.Response, error) {
 	client := r.client.R().
 		SetHeader("Content-Type", "application/json").
-		SetHeaders(headers).SetQueryParams(params)
+		SetHeaders(headers).SetQueryParams(params).
+		SetSuccessResult(result)
 
-	r.logField = append(r.logField, logger.Any("url", url))
-	r.logField = append(r.logField, logger.Any("headers", headers))
-	r.logField = append(r.logField, logger.Any("params", params))
-	r.logField = append(r.logField, logger.Any("method", "DELETE"))
-
-	if r.cfg.Debug {
-		client.EnableTrace()
-	}
-	response, err := client.SetSuccessResult(result).Delete(url)
-	if err != nil {
-		logger.Error(logger.SetMessageFormat("Error Delete request"), r.logField...)
-		return nil, err
-	}
-
-	if response.IsErrorState() {
-		logger.Error(logger.SetMessageFormat("Error State Delete request"), r.logField...)
-		return response, nil
-	}
-	r.logField = append(r.logField, logger.Any("duration", response.TotalTime()))
-	logger.Info(logger.SetMessageFormat("Success Delete request"), r.logField...)
-	return response, nil
+	return r.send("DELETE", "Delete", url, client,
+		logger.Any("headers", headers),
+		logger.Any("params", params),
+	)
 }
 
 func (r *reqsPkg) Upload(url string, body, headers map[string]string,
 	param, filename string, reader io.Reader, result interface{}) (*req.Response, error) {
 	client := r.client.R().
-		SetHeaders(headers).SetBody(body)
+		SetHeaders(headers).
+		SetSuccessResult(result).
+		SetFormData(body).
+		SetFileReader(param, filename, reader)
+
+	return r.send("POST", "POST", url, client,
+		logger.Any("body", body),
+	)
+}
 
-	r.logField = append(r.logField, logger.Any("url", url))
-	r.logField = append(r.logField, logger.Any("body", body))
-	r.logField = append(r.logField, logger.Any("method", "POST"))
+// send execute the request and log its outcome, label is the method name
+// used in the log message.
+func (r *reqsPkg) send(method, label, url string, client *req.Request, fields ...logger.Field) (*req.Response, error) {
+	logField := append([]logger.Field{}, r.logField...)
+	logField = append(logField, logger.Any("url", url))
+	logField = append(logField, fields...)
+	logField = append(logField, logger.Any("method", method))
 
 	if r.cfg.Debug {
 		client.EnableTrace()
 	}
-	response, err := client.SetSuccessResult(result).SetFormData(body).SetFileReader(param, filename, reader).Post(url)
+	response, err := client.Send(method, url)
 	if err != nil {
-		logger.Error(logger.SetMessageFormat("Error POST request"), r.logField...)
+		logger.Error(logger.SetMessageFormat("Error %s request", label), logField...)
 		return nil, err
 	}
 
 	if response.IsErrorState() {
-		logger.Error(logger.SetMessageFormat("Error State POST request"), r.logField...)
+		logger.Error(logger.SetMessageFormat("Error State %s request", label), logField...)
 		return response, nil
 	}
-	r.logField = append(r.logField, logger.Any("duration", response.TotalTime()))
-	logger.Info(logger.SetMessageFormat("Success POST request"), r.logField...)
+	logField = append(logField, logger.Any("duration", response.TotalTime()))
+	logger.Info(logger.SetMessageFormat("Success %s request", label), logField...)
 	return response, nil
 }
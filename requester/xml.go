@@ -0,0 +1,124 @@
+package requester
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+
+	"github.com/imroc/req/v3"
+)
+
+const (
+	soapEnvelopeNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+)
+
+// SOAPFault fault returned in a SOAP response body
+type SOAPFault struct {
+	Code   string `xml:"faultcode"`
+	String string `xml:"faultstring"`
+	Actor  string `xml:"faultactor"`
+	Detail string `xml:"detail"`
+}
+
+func (f *SOAPFault) Error() string {
+	return fmt.Sprintf("soap fault %s: %s", f.Code, f.String)
+}
+
+type soapEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    soapBody `xml:"Body"`
+}
+
+type soapBody struct {
+	Fault   *SOAPFault `xml:"Fault"`
+	Content []byte     `xml:",innerxml"`
+}
+
+// marshalXML encode body to XML, []byte and string are sent as is.
+func marshalXML(body interface{}) ([]byte, error) {
+	switch v := body.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return xml.Marshal(v)
+	}
+}
+
+// PostXML send body encoded as XML and decode the XML response into result
+func (r *reqsPkg) PostXML(url string, body interface{}, headers map[string]string, result interface{}) (*req.Response, error) {
+	data, err := marshalXML(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal xml body got: %w", err)
+	}
+	client := r.client.R().
+		SetHeader("Content-Type", "application/xml").
+		SetHeader("Accept", "application/xml").
+		SetHeaders(headers).
+		SetBodyBytes(data)
+
+	response, err := r.send("POST", "POST XML", url, client,
+		logger.Any("headers", headers),
+		logger.Any("body", string(data)),
+	)
+	if err != nil || response.IsErrorState() || result == nil {
+		return response, err
+	}
+	if err := xml.Unmarshal(response.Bytes(), result); err != nil {
+		return response, fmt.Errorf("unmarshal xml response got: %w", err)
+	}
+	return response, nil
+}
+
+// SOAP send body wrapped in a SOAP 1.1 envelope, result receive the content
+// of the response body and a fault is returned as *SOAPFault.
+func (r *reqsPkg) SOAP(url, action string, body interface{}, headers map[string]string, result interface{}) (*req.Response, error) {
+	data, err := marshalXML(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal soap body got: %w", err)
+	}
+	var envelope bytes.Buffer
+	envelope.WriteString(xml.Header)
+	envelope.WriteString(`<soap:Envelope xmlns:soap="` + soapEnvelopeNamespace + `"><soap:Body>`)
+	envelope.Write(data)
+	envelope.WriteString(`</soap:Body></soap:Envelope>`)
+
+	client := r.client.R().
+		SetHeader("Content-Type", "text/xml; charset=utf-8").
+		SetHeader("SOAPAction", action).
+		SetHeaders(headers).
+		SetBodyBytes(envelope.Bytes())
+
+	response, err := r.send("POST", "SOAP", url, client,
+		logger.Any("headers", headers),
+		logger.Any("action", action),
+		logger.Any("body", envelope.String()),
+	)
+	if err != nil {
+		return response, err
+	}
+
+	// faults are sent with an error status, so the body is read regardless
+	out := &soapEnvelope{}
+	if err := xml.Unmarshal(response.Bytes(), out); err != nil {
+		if response.IsErrorState() {
+			return response, nil
+		}
+		return response, fmt.Errorf("unmarshal soap response got: %w", err)
+	}
+	if out.Body.Fault != nil {
+		return response, out.Body.Fault
+	}
+	if result == nil {
+		return response, nil
+	}
+	if err := xml.Unmarshal(out.Body.Content, result); err != nil {
+		return response, fmt.Errorf("unmarshal soap body got: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,106 @@
+package requester
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type rateRequest struct {
+	XMLName xml.Name `xml:"GetRate"`
+	Origin  string   `xml:"Origin"`
+}
+
+type rateResponse struct {
+	XMLName xml.Name `xml:"GetRateResponse"`
+	Price   int      `xml:"Price"`
+}
+
+func TestSOAP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/xml")
+		if r.Header.Get("SOAPAction") != "GetRate" {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault><faultcode>soap:Client</faultcode><faultstring>unknown action</faultstring></soap:Fault></soap:Body></soap:Envelope>`))
+			return
+		}
+		assert.Contains(t, string(body), `<soap:Body><GetRate><Origin>JKT</Origin></GetRate></soap:Body>`)
+		_, _ = w.Write([]byte(`<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><GetRateResponse><Price>9000</Price></GetRateResponse></soap:Body></soap:Envelope>`))
+	}))
+	defer srv.Close()
+
+	r := NewRequester(&Config{Timeout: 5})
+	result := &rateResponse{}
+	_, err := r.SOAP(srv.URL, "GetRate", &rateRequest{Origin: "JKT"}, nil, result)
+	assert.NoError(t, err)
+	assert.Equal(t, 9000, result.Price)
+
+	_, err = r.SOAP(srv.URL, "Unknown", &rateRequest{Origin: "JKT"}, nil, result)
+	fault, ok := err.(*SOAPFault)
+	assert.True(t, ok)
+	assert.Equal(t, "unknown action", fault.String)
+}
+
+func TestPostXML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "application/xml", r.Header.Get("Content-Type"))
+		assert.Equal(t, "kaj", r.Header.Get("X-Tenant"))
+		if string(body) == "<broken/>" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`<error>bad request</error>`))
+			return
+		}
+		assert.Equal(t, `<GetRate><Origin>JKT</Origin></GetRate>`, string(body))
+		_, _ = w.Write([]byte(`<GetRateResponse><Price>9000</Price></GetRateResponse>`))
+	}))
+	defer srv.Close()
+
+	r := NewRequester(&Config{Timeout: 5})
+	headers := map[string]string{"X-Tenant": "kaj"}
+	result := &rateResponse{}
+	resp, err := r.PostXML(srv.URL, &rateRequest{Origin: "JKT"}, headers, result)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 9000, result.Price)
+
+	// an error status is returned with the response, the body is not decoded
+	result = &rateResponse{}
+	resp, err = r.PostXML(srv.URL, "<broken/>", headers, result)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Zero(t, result.Price)
+}
+
+func TestRetryIdempotentOnly(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		// drop the connection without a response
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if assert.NoError(t, err) {
+			_ = conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	r := NewRequester(&Config{Timeout: 5, RetryCount: 2})
+	_, err := r.GET(srv.URL, nil, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), atomic.SwapInt32(&attempts, 0))
+
+	_, err = r.PostXML(srv.URL, &rateRequest{Origin: "JKT"}, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.SwapInt32(&attempts, 0), "a POST may have been processed, it is not retried")
+
+	r = NewRequester(&Config{Timeout: 5, RetryCount: 2, RetryNonIdempotent: true})
+	_, err = r.SOAP(srv.URL, "GetRate", &rateRequest{Origin: "JKT"}, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), atomic.SwapInt32(&attempts, 0))
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Dispatcher sends signed webhooks and retries the failed deliveries
+type Dispatcher interface {
+	// Send queue a new delivery of payload to url, it is sent by Run with
+	// headers on top of the signature and webhook headers. headers may be nil.
+	Send(ctx context.Context, event, url string, payload interface{}, headers map[string]string) (*Delivery, error)
+	// Run process due deliveries until ctx is cancelled.
+	Run(ctx context.Context) error
+}
+
+// Store persist the delivery queue
+type Store interface {
+	Save(ctx context.Context, d *Delivery) error
+	// Claim return up to limit pending deliveries due at now and hide them
+	// from other claims until now + lease, so a crashed sender does not lose them.
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*Delivery, error)
+	Get(ctx context.Context, id string) (*Delivery, error)
+}
+
+type Config struct {
+	// Secret used to HMAC-SHA256 sign the payload
+	Secret string
+	// SignatureHeader defaults to X-Webhook-Signature
+	SignatureHeader string
+	// TimestampHeader defaults to X-Webhook-Timestamp
+	TimestampHeader string
+	// RetryIntervalSecond is the wait before each retry, the delivery is
+	// failed once every interval is used. Defaults to 1m, 5m, 30m, 1h, 3h, 6h, 12h.
+	RetryIntervalSecond []int
+	// PollIntervalSecond how often Run look for due deliveries, defaults to 5.
+	PollIntervalSecond int
+	// BatchSize maximum deliveries sent per poll, defaults to 50.
+	BatchSize int
+	// LeaseSecond how long a claimed delivery is hidden from other senders, defaults to 60.
+	LeaseSecond int
+}
+
+// Delivery a webhook and its delivery history
+type Delivery struct {
+	ID            string            `json:"id" bson:"_id"`
+	Event         string            `json:"event" bson:"event"`
+	URL           string            `json:"url" bson:"url"`
+	Body          []byte            `json:"body" bson:"body"`
+	Headers       map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
+	Status        string            `json:"status" bson:"status"`
+	Attempts      []Attempt         `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at" bson:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at" bson:"updated_at"`
+}
+
+// Attempt result of a single delivery try
+type Attempt struct {
+	At         time.Time     `json:"at" bson:"at"`
+	StatusCode int           `json:"status_code" bson:"status_code"`
+	Error      string        `json:"error,omitempty" bson:"error,omitempty"`
+	Duration   time.Duration `json:"duration" bson:"duration"`
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+	"github.com/kiriminaja/kaj-golang-pkg/requester"
+	"github.com/kiriminaja/kaj-golang-pkg/util"
+)
+
+const (
+	defaultPollIntervalSecond = 5
+	defaultBatchSize          = 50
+	defaultLeaseSecond        = 60
+)
+
+var defaultRetryIntervalSecond = []int{60, 300, 1800, 3600, 10800, 21600, 43200}
+
+type dispatcher struct {
+	cfg    *Config
+	client requester.RequesterContract
+	store  Store
+	now    func() time.Time
+}
+
+// NewDispatcher return webhook dispatcher sending through client, cfg is
+// copied with its defaults.
+func NewDispatcher(cfg *Config, client requester.RequesterContract, store Store) Dispatcher {
+	c := *cfg
+	c.RetryIntervalSecond = append([]int{}, cfg.RetryIntervalSecond...)
+	if c.SignatureHeader == "" {
+		c.SignatureHeader = defaultSignatureHeader
+	}
+	if c.TimestampHeader == "" {
+		c.TimestampHeader = defaultTimestampHeader
+	}
+	if len(c.RetryIntervalSecond) == 0 {
+		c.RetryIntervalSecond = append(c.RetryIntervalSecond, defaultRetryIntervalSecond...)
+	}
+	if c.PollIntervalSecond < 1 {
+		c.PollIntervalSecond = defaultPollIntervalSecond
+	}
+	if c.BatchSize < 1 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.LeaseSecond < 1 {
+		c.LeaseSecond = defaultLeaseSecond
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &dispatcher{
+		cfg:    &c,
+		client: client,
+		store:  store,
+		now:    time.Now,
+	}
+}
+
+func (w *dispatcher) Send(ctx context.Context, event, url string, payload interface{}, headers map[string]string) (*Delivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal webhook payload got: %w", err)
+	}
+	now := w.now()
+	d := &Delivery{
+		ID:            util.GenerateUUID(),
+		Event:         event,
+		URL:           url,
+		Body:          body,
+		Status:        StatusPending,
+		Attempts:      []Attempt{},
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if len(headers) > 0 {
+		d.Headers = make(map[string]string, len(headers))
+		for k, v := range headers {
+			d.Headers[k] = v
+		}
+	}
+	if err := w.store.Save(ctx, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (w *dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(w.cfg.PollIntervalSecond) * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := w.process(ctx); err != nil {
+			logger.Error(logger.SetMessageFormat("[webhook] claim deliveries got: %s", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// process send every due delivery once, it stops when ctx is done and the
+// deliveries left are claimed again once their lease expires.
+func (w *dispatcher) process(ctx context.Context) error {
+	deliveries, err := w.store.Claim(ctx, w.now(), w.cfg.BatchSize, time.Duration(w.cfg.LeaseSecond)*time.Second)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		w.deliver(ctx, d)
+		// an attempt cut short by ctx is not recorded
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := w.store.Save(ctx, d); err != nil {
+			logger.Error(logger.SetMessageFormat("[webhook] save delivery %s got: %s", d.ID, err.Error()))
+		}
+	}
+	return nil
+}
+
+// deliver send d once with ctx, the requester retries and their backoff
+// stop when ctx is done.
+func (w *dispatcher) deliver(ctx context.Context, d *Delivery) {
+	start := w.now()
+	headers := make(map[string]string, len(d.Headers)+4)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	// the signature headers are set last so a delivery header cannot
+	// replace them
+	headers[w.cfg.TimestampHeader] = strconv.FormatInt(start.Unix(), 10)
+	headers[w.cfg.SignatureHeader] = Sign([]byte(w.cfg.Secret), start.Unix(), d.Body)
+	headers["X-Webhook-Id"] = d.ID
+	headers["X-Webhook-Event"] = d.Event
+
+	attempt := Attempt{At: start}
+	response, err := w.client.RAW().
+		SetContext(ctx).
+		SetHeaders(headers).
+		SetBodyBytes(d.Body).
+		Post(d.URL)
+	// a request failing before a response has no http response
+	if response != nil && response.Response != nil {
+		attempt.StatusCode = response.StatusCode
+		attempt.Duration = response.TotalTime()
+	}
+	switch {
+	case err != nil:
+		attempt.Error = err.Error()
+	case response.IsErrorState():
+		attempt.Error = fmt.Sprintf("unexpected status %s", response.Status)
+	}
+	d.Attempts = append(d.Attempts, attempt)
+	d.UpdatedAt = w.now()
+
+	if attempt.Error == "" {
+		d.Status = StatusDelivered
+		return
+	}
+
+	retry := len(d.Attempts) - 1
+	if retry >= len(w.cfg.RetryIntervalSecond) {
+		d.Status = StatusFailed
+		logger.Error(logger.SetMessageFormat("[webhook] delivery %s to %s failed after %d attempts: %s", d.ID, d.URL, len(d.Attempts), attempt.Error))
+		return
+	}
+	d.NextAttemptAt = d.UpdatedAt.Add(time.Duration(w.cfg.RetryIntervalSecond[retry]) * time.Second)
+	logger.Warn(logger.SetMessageFormat("[webhook] delivery %s to %s attempt %d got: %s, retry at %s", d.ID, d.URL, len(d.Attempts), attempt.Error, d.NextAttemptAt))
+}
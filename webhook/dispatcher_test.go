@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/requester"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcherRetry(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(defaultTimestampHeader), 10, 64)
+		assert.Equal(t, Sign([]byte("secret"), ts, body), r.Header.Get(defaultSignatureHeader))
+		assert.Equal(t, "order.updated", r.Header.Get("X-Webhook-Event"))
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	d := NewDispatcher(&Config{
+		Secret:              "secret",
+		RetryIntervalSecond: []int{60},
+	}, requester.NewRequester(&requester.Config{Timeout: 5}), store).(*dispatcher)
+	d.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	delivery, err := d.Send(ctx, "order.updated", srv.URL, map[string]string{"awb": "KAJ001"}, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.process(ctx))
+	got, _ := store.Get(ctx, delivery.ID)
+	assert.Equal(t, StatusPending, got.Status)
+	assert.Len(t, got.Attempts, 1)
+	assert.Equal(t, http.StatusBadGateway, got.Attempts[0].StatusCode)
+	assert.Equal(t, now.Add(time.Minute), got.NextAttemptAt)
+
+	// not due yet
+	assert.NoError(t, d.process(ctx))
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, d.process(ctx))
+	got, _ = store.Get(ctx, delivery.ID)
+	assert.Equal(t, StatusDelivered, got.Status)
+	assert.Len(t, got.Attempts, 2)
+}
+
+func TestDispatcherExhausted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	d := NewDispatcher(&Config{
+		Secret:              "secret",
+		RetryIntervalSecond: []int{1},
+	}, requester.NewRequester(&requester.Config{Timeout: 5}), store).(*dispatcher)
+	d.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	delivery, _ := d.Send(ctx, "order.updated", srv.URL, map[string]string{}, nil)
+	assert.NoError(t, d.process(ctx))
+	now = now.Add(time.Second)
+	assert.NoError(t, d.process(ctx))
+
+	got, _ := store.Get(ctx, delivery.ID)
+	assert.Equal(t, StatusFailed, got.Status)
+	assert.Len(t, got.Attempts, 2)
+}
+
+func TestDispatcherConfigCopy(t *testing.T) {
+	cfg := &Config{Secret: "secret"}
+	d := NewDispatcher(cfg, nil, nil).(*dispatcher)
+	assert.Empty(t, cfg.SignatureHeader, "the caller config is not changed")
+	assert.Empty(t, cfg.RetryIntervalSecond)
+
+	d.cfg.RetryIntervalSecond[0] = 1
+	assert.Equal(t, 60, defaultRetryIntervalSecond[0], "the defaults are not shared")
+}
+
+func TestDispatcherHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "kaj", r.Header.Get("X-Tenant"))
+		// a delivery header cannot replace the signature headers
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(defaultTimestampHeader), 10, 64)
+		assert.Equal(t, Sign([]byte("secret"), ts, body), r.Header.Get(defaultSignatureHeader))
+		assert.NotEqual(t, "forged", r.Header.Get("X-Webhook-Id"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+	d := NewDispatcher(&Config{Secret: "secret"}, requester.NewRequester(&requester.Config{Timeout: 5}), store).(*dispatcher)
+	ctx := context.Background()
+	headers := map[string]string{"X-Tenant": "kaj", defaultSignatureHeader: "forged", "X-Webhook-Id": "forged"}
+	delivery, err := d.Send(ctx, "order.updated", srv.URL, map[string]string{}, headers)
+	assert.NoError(t, err)
+	headers["X-Tenant"] = "changed"
+
+	assert.NoError(t, d.process(ctx))
+	got, _ := store.Get(ctx, delivery.ID)
+	assert.Equal(t, StatusDelivered, got.Status)
+	assert.Equal(t, "kaj", got.Headers["X-Tenant"])
+}
+
+func TestDispatcherCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	store := NewMemoryStore()
+	d := NewDispatcher(&Config{Secret: "secret"},
+		requester.NewRequester(&requester.Config{Timeout: 30, RetryCount: 3}), store).(*dispatcher)
+	ctx, cancel := context.WithCancel(context.Background())
+	delivery, _ := d.Send(ctx, "order.updated", srv.URL, map[string]string{}, nil)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	started := time.Now()
+	assert.NoError(t, d.process(ctx))
+	assert.Less(t, time.Since(started), 5*time.Second)
+
+	// the cut short attempt is not recorded
+	got, _ := store.Get(context.Background(), delivery.ID)
+	assert.Equal(t, StatusPending, got.Status)
+	assert.Empty(t, got.Attempts)
+}
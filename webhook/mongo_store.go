@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoStore struct {
+	adapter    mongodb.Adapter
+	collection string
+}
+
+// NewMongoStore return a Store persisting the queue in collection,
+// deliveries survive restarts and can be shared by several senders.
+func NewMongoStore(adapter mongodb.Adapter, collection string) Store {
+	return &mongoStore{adapter: adapter, collection: collection}
+}
+
+func (s *mongoStore) coll() *mongo.Collection {
+	return s.adapter.SetCollection(s.collection, s.adapter.OptionCollection())
+}
+
+func (s *mongoStore) Save(ctx context.Context, d *Delivery) error {
+	_, err := s.coll().ReplaceOne(ctx, bson.M{"_id": d.ID}, d, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]*Delivery, error) {
+	result := make([]*Delivery, 0)
+	filter := bson.M{
+		"status":          StatusPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	for len(result) < limit {
+		d := &Delivery{}
+		err := s.coll().FindOneAndUpdate(ctx, filter, update, opts).Decode(d)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+func (s *mongoStore) Get(ctx context.Context, id string) (*Delivery, error) {
+	d := &Delivery{}
+	err := s.coll().FindOne(ctx, bson.M{"_id": id}).Decode(d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
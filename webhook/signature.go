@@ -0,0 +1,24 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+const (
+	defaultSignatureHeader = "X-Webhook-Signature"
+	defaultTimestampHeader = "X-Webhook-Timestamp"
+	signaturePrefix        = "sha256="
+)
+
+// Sign return the signature of body sent at timestamp (unix second),
+// computed as hex(HMAC-SHA256(secret, "<timestamp>.<body>")).
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
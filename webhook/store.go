@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+type memoryStore struct {
+	mu         sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+// NewMemoryStore return a Store kept in memory, the queue is lost on restart.
+func NewMemoryStore() Store {
+	return &memoryStore{deliveries: map[string]*Delivery{}}
+}
+
+func (s *memoryStore) Save(_ context.Context, d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *d
+	cp.Attempts = append([]Attempt{}, d.Attempts...)
+	s.deliveries[d.ID] = &cp
+	return nil
+}
+
+func (s *memoryStore) Claim(_ context.Context, now time.Time, limit int, lease time.Duration) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := make([]*Delivery, 0)
+	for _, d := range s.deliveries {
+		if d.Status == StatusPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	result := make([]*Delivery, 0, len(due))
+	for _, d := range due {
+		d.NextAttemptAt = now.Add(lease)
+		cp := *d
+		cp.Attempts = append([]Attempt{}, d.Attempts...)
+		result = append(result, &cp)
+	}
+	return result, nil
+}
+
+func (s *memoryStore) Get(_ context.Context, id string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return nil, ErrDeliveryNotFound
+	}
+	cp := *d
+	cp.Attempts = append([]Attempt{}, d.Attempts...)
+	return &cp, nil
+}
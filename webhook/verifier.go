@@ -0,0 +1,324 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kiriminaja/kaj-golang-pkg/cache"
+	"github.com/kiriminaja/kaj-golang-pkg/logger"
+)
+
+const (
+	AlgorithmHMACSHA256 = "hmac-sha256"
+	AlgorithmHMACSHA512 = "hmac-sha512"
+	AlgorithmRSASHA256  = "rsa-sha256"
+
+	EncodingHex    = "hex"
+	EncodingBase64 = "base64"
+
+	defaultToleranceSecond = 300
+	defaultReplayPrefix    = "webhook:replay:"
+	defaultMaxBodyBytes    = 1 << 20
+)
+
+var (
+	ErrMissingSignature = errors.New("webhook signature is missing")
+	ErrInvalidSignature = errors.New("webhook signature is invalid")
+	ErrInvalidTimestamp = errors.New("webhook timestamp is invalid")
+	ErrTimestampExpired = errors.New("webhook timestamp is outside tolerance")
+	ErrReplayed         = errors.New("webhook already received")
+	// ErrReplayUnavailable the replay cache failed, the webhook may be retried
+	ErrReplayUnavailable = errors.New("webhook replay cache is unavailable")
+	ErrBodyTooLarge      = errors.New("webhook body is too large")
+)
+
+// Verifier check signature of inbound webhooks
+type Verifier interface {
+	// Verify check the signature of body against the request headers.
+	Verify(ctx context.Context, header http.Header, body []byte) error
+	// Middleware reject unsigned requests with 401 before calling next.
+	Middleware(next http.Handler) http.Handler
+}
+
+type VerifierConfig struct {
+	// Algorithm one of hmac-sha256 (default), hmac-sha512, rsa-sha256
+	Algorithm string
+	// Secret shared key for hmac algorithms
+	Secret string
+	// PublicKey PEM encoded public key for rsa algorithms
+	PublicKey string
+	// Encoding of the signature header value, hex (default for hmac) or base64 (default for rsa).
+	// A "sha256=" style prefix on the value is ignored.
+	Encoding string
+	// SignatureHeader defaults to X-Webhook-Signature
+	SignatureHeader string
+	// TimestampHeader holds the unix time of the signature, when set the
+	// signed content is "<timestamp>.<body>" instead of the body only.
+	TimestampHeader string
+	// ToleranceSecond maximum age of the timestamp, defaults to 300.
+	ToleranceSecond int
+	// ReplayCache when set reject a signature seen within the tolerance
+	// window. A cache.AtomicCacher such as cache.NewCache reject concurrent
+	// replays, with a plain Cacher two requests with the same signature
+	// arriving together can both pass.
+	ReplayCache cache.Cacher
+	// ReplayPrefix key prefix in ReplayCache, defaults to webhook:replay:
+	ReplayPrefix string
+	// MaxBodyBytes largest body read by VerifyRequest and Middleware,
+	// defaults to 1 MiB.
+	MaxBodyBytes int64
+}
+
+type verifier struct {
+	cfg       *VerifierConfig
+	hash      func() hash.Hash
+	publicKey *rsa.PublicKey
+	now       func() time.Time
+}
+
+// NewVerifier return verifier of inbound webhooks
+func NewVerifier(cfg *VerifierConfig) (Verifier, error) {
+	v := &verifier{cfg: cfg, now: time.Now}
+
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = AlgorithmHMACSHA256
+	}
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = defaultSignatureHeader
+	}
+	if cfg.ToleranceSecond < 1 {
+		cfg.ToleranceSecond = defaultToleranceSecond
+	}
+	if cfg.ReplayPrefix == "" {
+		cfg.ReplayPrefix = defaultReplayPrefix
+	}
+	if cfg.MaxBodyBytes < 1 {
+		cfg.MaxBodyBytes = defaultMaxBodyBytes
+	}
+
+	switch cfg.Algorithm {
+	case AlgorithmHMACSHA256, AlgorithmHMACSHA512:
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("webhook verifier %s requires a secret", cfg.Algorithm)
+		}
+		v.hash = sha256.New
+		if cfg.Algorithm == AlgorithmHMACSHA512 {
+			v.hash = sha512.New
+		}
+		if cfg.Encoding == "" {
+			cfg.Encoding = EncodingHex
+		}
+	case AlgorithmRSASHA256:
+		key, err := parsePublicKey(cfg.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		v.publicKey = key
+		if cfg.Encoding == "" {
+			cfg.Encoding = EncodingBase64
+		}
+	default:
+		return nil, fmt.Errorf("webhook verifier algorithm only available : %q, %q, %q, on setting value : %q",
+			AlgorithmHMACSHA256, AlgorithmHMACSHA512, AlgorithmRSASHA256, cfg.Algorithm)
+	}
+
+	if cfg.Encoding != EncodingHex && cfg.Encoding != EncodingBase64 {
+		return nil, fmt.Errorf("webhook verifier invalid signature encoding %q", cfg.Encoding)
+	}
+	return v, nil
+}
+
+func parsePublicKey(raw string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(raw))
+	if block == nil {
+		return nil, errors.New("webhook verifier public key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse webhook public key got: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("webhook verifier public key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+func (v *verifier) Verify(ctx context.Context, header http.Header, body []byte) error {
+	raw := strings.TrimSpace(header.Get(v.cfg.SignatureHeader))
+	if raw == "" {
+		return ErrMissingSignature
+	}
+	signature, err := v.decode(trimAlgorithmPrefix(raw))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	content := body
+	if v.cfg.TimestampHeader != "" {
+		ts := strings.TrimSpace(header.Get(v.cfg.TimestampHeader))
+		if err := v.checkTimestamp(ts); err != nil {
+			return err
+		}
+		content = append([]byte(ts+"."), body...)
+	}
+
+	if err := v.check(content, signature); err != nil {
+		return err
+	}
+	return v.checkReplay(ctx, signature)
+}
+
+// trimAlgorithmPrefix drop a "sha256=" style prefix, base64 padding is kept.
+func trimAlgorithmPrefix(raw string) string {
+	i := strings.Index(raw, "=")
+	if i > 0 && strings.Trim(raw[i:], "=") != "" {
+		return raw[i+1:]
+	}
+	return raw
+}
+
+func (v *verifier) decode(raw string) ([]byte, error) {
+	if v.cfg.Encoding == EncodingHex {
+		return hex.DecodeString(raw)
+	}
+	if b, err := base64.StdEncoding.DecodeString(raw); err == nil {
+		return b, nil
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(raw, "="))
+}
+
+func (v *verifier) checkTimestamp(ts string) error {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	// milliseconds precision timestamp
+	if sec > 1e12 {
+		sec /= 1000
+	}
+	age := v.now().Sub(time.Unix(sec, 0))
+	if age < 0 {
+		age = -age
+	}
+	if age > time.Duration(v.cfg.ToleranceSecond)*time.Second {
+		return ErrTimestampExpired
+	}
+	return nil
+}
+
+func (v *verifier) check(content, signature []byte) error {
+	if v.publicKey != nil {
+		digest := sha256.Sum256(content)
+		if rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	mac := hmac.New(v.hash, []byte(v.cfg.Secret))
+	mac.Write(content)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// checkReplay remember the signature for twice the tolerance, a signature is
+// unique per timestamp and body so a second sighting is a replay. The key is
+// the digest of the decoded signature, the same signature encoded another
+// way is the same key.
+func (v *verifier) checkReplay(ctx context.Context, signature []byte) error {
+	if v.cfg.ReplayCache == nil {
+		return nil
+	}
+	digest := sha256.Sum256(signature)
+	key := v.cfg.ReplayPrefix + hex.EncodeToString(digest[:])
+	exp := 2 * time.Duration(v.cfg.ToleranceSecond) * time.Second
+
+	if atomic, ok := v.cfg.ReplayCache.(cache.AtomicCacher); ok {
+		set, err := atomic.SetNX(ctx, key, 1, exp)
+		if err != nil {
+			return fmt.Errorf("%w got: %s", ErrReplayUnavailable, err.Error())
+		}
+		if !set {
+			return ErrReplayed
+		}
+		return nil
+	}
+
+	seen, err := v.cfg.ReplayCache.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%w got: %s", ErrReplayUnavailable, err.Error())
+	}
+	if len(seen) > 0 {
+		return ErrReplayed
+	}
+	if err := v.cfg.ReplayCache.Set(ctx, key, 1, exp); err != nil {
+		return fmt.Errorf("%w got: %s", ErrReplayUnavailable, err.Error())
+	}
+	return nil
+}
+
+func (v *verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := VerifyRequest(v, r)
+		if err != nil {
+			logger.Warn(logger.SetMessageFormat("[webhook] reject %s %s got: %s", r.Method, r.URL.Path, err.Error()))
+			http.Error(w, err.Error(), rejectStatus(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectStatus return the response status of a Middleware rejection, the
+// sender should retry a webhook rejected by an unavailable replay cache.
+func rejectStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrReplayUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusUnauthorized
+}
+
+// VerifyRequest read and verify the body of r, the returned body can be
+// decoded by the caller since r.Body is consumed. A body larger than
+// VerifierConfig.MaxBodyBytes return ErrBodyTooLarge.
+func VerifyRequest(v Verifier, r *http.Request) ([]byte, error) {
+	limit := int64(defaultMaxBodyBytes)
+	if vv, ok := v.(*verifier); ok {
+		limit = vv.cfg.MaxBodyBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	return body, v.Verify(r.Context(), r.Header, body)
+}
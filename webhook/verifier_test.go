@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapCache struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (c *mapCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.data[key], nil
+}
+
+func (c *mapCache) Set(_ context.Context, key string, val interface{}, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = []byte(strconv.Itoa(val.(int)))
+	return nil
+}
+
+func (c *mapCache) Delete(_ context.Context, key ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range key {
+		delete(c.data, k)
+	}
+	return nil
+}
+
+func TestVerifierHMAC(t *testing.T) {
+	v, err := NewVerifier(&VerifierConfig{
+		Secret:          "secret",
+		TimestampHeader: defaultTimestampHeader,
+		ReplayCache:     &mapCache{data: map[string][]byte{}},
+	})
+	assert.NoError(t, err)
+
+	body := []byte(`{"awb":"KAJ001"}`)
+	now := time.Now()
+	header := http.Header{}
+	header.Set(defaultTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	header.Set(defaultSignatureHeader, Sign([]byte("secret"), now.Unix(), body))
+
+	ctx := context.Background()
+	assert.NoError(t, v.Verify(ctx, header, body))
+	assert.ErrorIs(t, v.Verify(ctx, header, body), ErrReplayed)
+	assert.ErrorIs(t, v.Verify(ctx, header, []byte(`{"awb":"KAJ002"}`)), ErrInvalidSignature)
+	assert.ErrorIs(t, v.Verify(ctx, http.Header{}, body), ErrMissingSignature)
+
+	old := now.Add(-time.Hour)
+	header.Set(defaultTimestampHeader, strconv.FormatInt(old.Unix(), 10))
+	header.Set(defaultSignatureHeader, Sign([]byte("secret"), old.Unix(), body))
+	assert.ErrorIs(t, v.Verify(ctx, header, body), ErrTimestampExpired)
+}
+
+func TestVerifierRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	v, err := NewVerifier(&VerifierConfig{
+		Algorithm:       AlgorithmRSASHA256,
+		PublicKey:       string(pub),
+		SignatureHeader: "X-Courier-Signature",
+	})
+	assert.NoError(t, err)
+
+	body := []byte(`{"status":"delivered"}`)
+	digest := sha256.Sum256(body)
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	header := http.Header{}
+	header.Set("X-Courier-Signature", base64.StdEncoding.EncodeToString(sig))
+
+	assert.NoError(t, v.Verify(context.Background(), header, body))
+	assert.ErrorIs(t, v.Verify(context.Background(), header, []byte(`{}`)), ErrInvalidSignature)
+}
+
+func TestVerifierMiddleware(t *testing.T) {
+	v, _ := NewVerifier(&VerifierConfig{Secret: "secret", TimestampHeader: defaultTimestampHeader})
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, `{"awb":"KAJ001"}`, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	body := []byte(`{"awb":"KAJ001"}`)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	ts := time.Now().Unix()
+	r := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	r.Header.Set(defaultTimestampHeader, strconv.FormatInt(ts, 10))
+	r.Header.Set(defaultSignatureHeader, Sign([]byte("secret"), ts, body))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+// nxCache mapCache setting keys only when absent
+type nxCache struct {
+	mapCache
+}
+
+func (c *nxCache) SetNX(_ context.Context, key string, val interface{}, _ time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.data[key]; ok {
+		return false, nil
+	}
+	c.data[key] = []byte(strconv.Itoa(val.(int)))
+	return true, nil
+}
+
+type failingCache struct {
+	mapCache
+}
+
+func (c *failingCache) Get(context.Context, string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func signedHeader(body []byte) (http.Header, string) {
+	ts := time.Now().Unix()
+	header := http.Header{}
+	header.Set(defaultTimestampHeader, strconv.FormatInt(ts, 10))
+	signature := Sign([]byte("secret"), ts, body)
+	header.Set(defaultSignatureHeader, signature)
+	return header, signature
+}
+
+func TestVerifierReplayReencoded(t *testing.T) {
+	v, _ := NewVerifier(&VerifierConfig{
+		Secret:          "secret",
+		TimestampHeader: defaultTimestampHeader,
+		ReplayCache:     &mapCache{data: map[string][]byte{}},
+	})
+	body := []byte(`{"awb":"KAJ001"}`)
+	header, signature := signedHeader(body)
+	ctx := context.Background()
+	assert.NoError(t, v.Verify(ctx, header, body))
+
+	bare := strings.TrimPrefix(signature, signaturePrefix)
+	for _, replay := range []string{bare, strings.ToUpper(bare), signaturePrefix + strings.ToUpper(bare)} {
+		header.Set(defaultSignatureHeader, replay)
+		assert.ErrorIs(t, v.Verify(ctx, header, body), ErrReplayed, replay)
+	}
+}
+
+func TestVerifierConcurrentReplay(t *testing.T) {
+	v, _ := NewVerifier(&VerifierConfig{
+		Secret:          "secret",
+		TimestampHeader: defaultTimestampHeader,
+		ReplayCache:     &nxCache{mapCache{data: map[string][]byte{}}},
+	})
+	body := []byte(`{"awb":"KAJ001"}`)
+	header, _ := signedHeader(body)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	passed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v.Verify(context.Background(), header, body) == nil {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, passed)
+}
+
+func TestVerifierMiddlewareStatus(t *testing.T) {
+	body := []byte(`{"awb":"KAJ001"}`)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// the cache failing is not the sender's fault
+	v, _ := NewVerifier(&VerifierConfig{
+		Secret:          "secret",
+		TimestampHeader: defaultTimestampHeader,
+		ReplayCache:     &failingCache{mapCache{data: map[string][]byte{}}},
+	})
+	header, _ := signedHeader(body)
+	r := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	r.Header = header
+	rec := httptest.NewRecorder()
+	v.Middleware(next).ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	v, _ = NewVerifier(&VerifierConfig{Secret: "secret", TimestampHeader: defaultTimestampHeader, MaxBodyBytes: 8})
+	r = httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	r.Header = header
+	rec = httptest.NewRecorder()
+	v.Middleware(next).ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}